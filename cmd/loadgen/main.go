@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"metrics/internal/compress"
+	"metrics/internal/config"
+	"metrics/internal/security"
+	s "metrics/internal/service"
+)
+
+// maxRate ограничивает -rate: при большей частоте период тикера
+// округляется до нуля, и NewTicker паникует.
+const maxRate = 1e9
+
+var ErrOptions = errors.New("bad options")
+
+type options struct {
+	addr     string
+	key      string
+	agents   int
+	metrics  int
+	rate     float64
+	duration time.Duration
+	timeout  time.Duration
+	prefix   string
+	verify   bool
+}
+
+func parseFlags() *options {
+	opts := &options{}
+	flag.StringVar(&opts.addr, "a", "localhost:8080", "Server address arg: -a <host:port>")
+	flag.StringVar(&opts.key, "k", os.Getenv("KEY"), "Sign key arg: -k <keystring>")
+	flag.IntVar(&opts.agents, "agents", 10, "Simulated agents arg: -agents <n>")
+	flag.IntVar(&opts.metrics, "metrics", 20, "Metrics per batch arg: -metrics <m>")
+	flag.Float64Var(&opts.rate, "rate", 1, "Batches per second per agent arg: -rate <r>")
+	flag.DurationVar(&opts.duration, "duration", 30*time.Second, "Test duration arg: -duration <d>")
+	flag.DurationVar(&opts.timeout, "timeout", 5*time.Second, "Request timeout arg: -timeout <d>")
+	flag.StringVar(&opts.prefix, "prefix", "", "Metric ID prefix arg: -prefix <str> (default: loadgen<unix time>)")
+	flag.BoolVar(&opts.verify, "verify", false, "Verify counter totals after the run")
+	flag.Parse()
+	if opts.prefix == "" {
+		opts.prefix = "loadgen" + strconv.FormatInt(time.Now().Unix(), 10)
+	}
+	if !strings.HasPrefix(opts.addr, "http://") && !strings.HasPrefix(opts.addr, "https://") {
+		opts.addr = "http://" + opts.addr
+	}
+	return opts
+}
+
+func (opts *options) check() error {
+	if opts.agents <= 0 || opts.metrics <= 0 || !(opts.rate > 0) {
+		return fmt.Errorf("%w: -agents, -metrics and -rate must be positive", ErrOptions)
+	}
+	if opts.rate > maxRate {
+		return fmt.Errorf("%w: -rate must not exceed %g", ErrOptions, maxRate)
+	}
+	return nil
+}
+
+func main() {
+	opts := parseFlags()
+	if err := opts.check(); err != nil {
+		fmt.Fprintf(os.Stderr, "loadgen: %v\n", err)
+		os.Exit(2)
+	}
+	cx, complete := config.CompletionCtx()
+	defer complete()
+
+	runCx, cancel := ctx.WithTimeout(cx, opts.duration)
+	defer cancel()
+
+	client := &http.Client{
+		Timeout: opts.timeout,
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: opts.agents,
+		},
+	}
+	total := newAgentStats()
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < opts.agents; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			st := runAgent(cx, runCx, client, opts, n)
+			mtx.Lock()
+			total.merge(st)
+			mtx.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	total.report(os.Stdout, time.Since(start))
+
+	if !opts.verify {
+		return
+	}
+	if err := verify(cx, client, opts, total); err != nil {
+		fmt.Fprintf(os.Stderr, "verify: %v\n", err)
+		complete()
+		os.Exit(1)
+	}
+}
+
+// runAgent шлет батчи с заданной частотой, как агент: половина метрик -
+// gauge, половина - counter со случайной дельтой. По окончании runCx новые
+// батчи не отправляются, но начатый запрос дожидается ответа, иначе его
+// результат для сверки неизвестен.
+func runAgent(cx, runCx ctx.Context, client *http.Client, opts *options, n int) *agentStats {
+	st := newAgentStats()
+	r := rand.New(rand.NewSource(time.Now().UnixNano() + int64(n)))
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.rate))
+	defer ticker.Stop()
+
+	batch := make([]*s.Metrics, opts.metrics)
+	for {
+		for i := range batch {
+			id := fmt.Sprintf("%s_a%d_m%d", opts.prefix, n, i)
+			if i%2 == 0 {
+				delta := r.Int63n(10) + 1
+				batch[i] = &s.Metrics{ID: id, MType: s.Counter, Delta: &delta}
+			} else {
+				val := r.Float64() * 1000
+				batch[i] = &s.Metrics{ID: id, MType: s.Gauge, Value: &val}
+			}
+		}
+		status, latency, err := send(cx, client, opts, batch)
+		st.record(batch, status, latency, err)
+		select {
+		case <-runCx.Done():
+			return st
+		case <-ticker.C:
+		}
+	}
+}
+
+func send(cx ctx.Context, client *http.Client, opts *options, batch []*s.Metrics) (int, time.Duration, error) {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return 0, 0, err
+	}
+	body, err := compress.Compress(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	req, err := http.NewRequestWithContext(cx, http.MethodPost, opts.addr+"/updates/", bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	if opts.key != "" {
+		req.Header.Set("HashSHA256", security.Hash(&data, opts.key))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, time.Since(start), err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, time.Since(start), nil
+}
+
+var ErrMismatch = errors.New("counter totals mismatch")
+
+// verify сверяет итоги счетчиков на сервере с подтвержденными отправками.
+// Батчи без ответа могли быть применены, поэтому при них сверка неточна.
+func verify(cx ctx.Context, client *http.Client, opts *options, st *agentStats) error {
+	req, err := http.NewRequestWithContext(cx, http.MethodGet, opts.addr+"/", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("list metrics: %s", resp.Status)
+	}
+	var items []struct {
+		ID    string `json:"id"`
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return fmt.Errorf("decode metrics: %w", err)
+	}
+	stored := make(map[string]int64, len(st.totals))
+	for _, it := range items {
+		if it.Type != s.Counter || !strings.HasPrefix(it.ID, opts.prefix+"_") {
+			continue
+		}
+		v, err := strconv.ParseInt(it.Value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("counter %s: %w", it.ID, err)
+		}
+		stored[it.ID] = v
+	}
+	mismatched := 0
+	for id, sent := range st.totals {
+		if got := stored[id]; got != sent {
+			mismatched++
+			fmt.Fprintf(os.Stderr, "  %s: sent %d, stored %d\n", id, sent, got)
+		}
+	}
+	fmt.Printf("verify:     %d counters checked, %d mismatched\n", len(st.totals), mismatched)
+	if mismatched == 0 {
+		return nil
+	}
+	if st.ambiguous > 0 {
+		return fmt.Errorf("%w: %d requests got no response or a 5xx and may have been applied",
+			ErrMismatch, st.ambiguous)
+	}
+	return ErrMismatch
+}
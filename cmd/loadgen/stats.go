@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	s "metrics/internal/service"
+)
+
+// agentStats собирается одним агентом без блокировок и сливается в конце.
+type agentStats struct {
+	latencies []time.Duration
+	requests  int
+	errors    map[string]int
+	metrics   int
+	// totals - подтвержденные сервером (200) суммы дельт счетчиков
+	totals map[string]int64
+	// ambiguous - ответ не получен или 5xx: неизвестно, применен ли батч
+	ambiguous int
+}
+
+func newAgentStats() *agentStats {
+	return &agentStats{
+		errors: make(map[string]int),
+		totals: make(map[string]int64),
+	}
+}
+
+// record учитывает результат отправки батча. Сервер мог применить батч и
+// ответить 5xx (например, потеряв соединение с БД после коммита), поэтому
+// такие ответы, как и ошибки транспорта, считаются неоднозначными.
+func (st *agentStats) record(batch []*s.Metrics, status int, latency time.Duration, err error) {
+	st.requests++
+	st.latencies = append(st.latencies, latency)
+	switch {
+	case err != nil:
+		st.errors["transport"]++
+		st.ambiguous++
+	case status != http.StatusOK:
+		st.errors["status "+strconv.Itoa(status)]++
+		if status >= http.StatusInternalServerError {
+			st.ambiguous++
+		}
+	default:
+		st.metrics += len(batch)
+		for _, m := range batch {
+			if m.IsCounter() {
+				st.totals[m.ID] += *m.Delta
+			}
+		}
+	}
+}
+
+func (st *agentStats) merge(other *agentStats) {
+	st.latencies = append(st.latencies, other.latencies...)
+	st.requests += other.requests
+	st.metrics += other.metrics
+	st.ambiguous += other.ambiguous
+	for k, v := range other.errors {
+		st.errors[k] += v
+	}
+	for k, v := range other.totals {
+		st.totals[k] += v
+	}
+}
+
+func (st *agentStats) failed() int {
+	n := 0
+	for _, v := range st.errors {
+		n += v
+	}
+	return n
+}
+
+// percentile ожидает отсортированный срез.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(p/100*float64(len(sorted))+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+func (st *agentStats) report(w io.Writer, elapsed time.Duration) {
+	sort.Slice(st.latencies, func(i, j int) bool { return st.latencies[i] < st.latencies[j] })
+	secs := elapsed.Seconds()
+	failed := st.failed()
+	fmt.Fprintf(w, "duration:   %s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "requests:   %d (%.1f req/s)\n", st.requests, float64(st.requests)/secs)
+	fmt.Fprintf(w, "metrics:    %d (%.1f metrics/s)\n", st.metrics, float64(st.metrics)/secs)
+	errRate := 0.0
+	if st.requests > 0 {
+		errRate = float64(failed) / float64(st.requests) * 100
+	}
+	fmt.Fprintf(w, "errors:     %d (%.2f%%)\n", failed, errRate)
+	keys := make([]string, 0, len(st.errors))
+	for k := range st.errors {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %-24s %d\n", k, st.errors[k])
+	}
+	fmt.Fprintf(w, "latency:    p50=%s p90=%s p99=%s max=%s\n",
+		percentile(st.latencies, 50),
+		percentile(st.latencies, 90),
+		percentile(st.latencies, 99),
+		percentile(st.latencies, 100))
+}
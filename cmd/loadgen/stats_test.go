@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	s "metrics/internal/service"
+)
+
+func TestPercentile(t *testing.T) {
+	lat := make([]time.Duration, 100)
+	for i := range lat {
+		lat[i] = time.Duration(i+1) * time.Millisecond
+	}
+	for _, tt := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, 50 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+		{0, time.Millisecond},
+	} {
+		if got := percentile(lat, tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %s, want %s", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile(nil) = %s", got)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	st := newAgentStats()
+	delta := int64(3)
+	batch := []*s.Metrics{{ID: "c", MType: s.Counter, Delta: &delta}}
+	st.record(batch, http.StatusOK, time.Millisecond, nil)
+	st.record(batch, http.StatusBadRequest, time.Millisecond, nil)
+	st.record(batch, http.StatusServiceUnavailable, time.Millisecond, nil)
+	st.record(batch, 0, time.Millisecond, errors.New("timeout"))
+	if st.requests != 4 || st.totals["c"] != 3 || st.ambiguous != 2 {
+		t.Fatalf("requests %d, total %d, ambiguous %d", st.requests, st.totals["c"], st.ambiguous)
+	}
+}
+
+func TestOptionsCheck(t *testing.T) {
+	for _, rate := range []float64{0, -1, math.NaN(), 2e9} {
+		opts := &options{agents: 1, metrics: 1, rate: rate}
+		if err := opts.check(); !errors.Is(err, ErrOptions) {
+			t.Errorf("rate %g: %v, want ErrOptions", rate, err)
+		}
+	}
+	if err := (&options{agents: 1, metrics: 1, rate: 1e9}).check(); err != nil {
+		t.Errorf("rate 1e9: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	ctx "context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"metrics/internal/compress"
+	"metrics/internal/security"
+)
+
+const (
+	defaultAddr    = "localhost:8080"
+	requestTimeout = 10 * time.Second
+)
+
+var ErrStatus = errors.New("unexpected response status")
+
+// client обращается к JSON-эндпоинтам сервера так же, как агент: тело
+// сжимается gzip, подпись HashSHA256 считается по несжатым данным.
+type client struct {
+	addr   string
+	key    string
+	format string
+	http   *http.Client
+}
+
+// clientFlags добавляет общие для сетевых команд флаги; значения по
+// умолчанию берутся из ADDRESS и KEY, как у агента. Формат вывода
+// проверяется до отправки запроса, чтобы не менять данные впустую.
+func clientFlags(fs *flag.FlagSet) func() (*client, error) {
+	addr := fs.String("a", envOr("ADDRESS", defaultAddr), "Server address arg: -a <host:port>")
+	key := fs.String("k", os.Getenv("KEY"), "Sign key arg: -k <keystring>")
+	format := fs.String("o", formatTable, "Output format arg: -o <table|json|csv>")
+	return func() (*client, error) {
+		if err := checkFormat(*format); err != nil {
+			return nil, err
+		}
+		addr := *addr
+		if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+			addr = "http://" + addr
+		}
+		return &client{
+			addr:   strings.TrimRight(addr, "/"),
+			key:    *key,
+			format: *format,
+			http:   &http.Client{},
+		}, nil
+	}
+}
+
+func envOr(name, def string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return def
+}
+
+func (cl *client) post(cx ctx.Context, path string, data []byte) ([]byte, error) {
+	body, err := compress.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(cx, http.MethodPost, cl.addr+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if cl.key != "" {
+		req.Header.Set("HashSHA256", security.Hash(&data, cl.key))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	return cl.do(req, requestTimeout)
+}
+
+func (cl *client) get(cx ctx.Context, path, accept string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(cx, http.MethodGet, cl.addr+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	return cl.do(req, requestTimeout)
+}
+
+// do выполняет запрос; ответ в gzip распаковывает сам транспорт, так как
+// Accept-Encoding выставляет он.
+func (cl *client) do(req *http.Request, timeout time.Duration) ([]byte, error) {
+	cx, cancel := ctx.WithTimeout(req.Context(), timeout)
+	defer cancel()
+	resp, err := cl.http.Do(req.WithContext(cx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return body, fmt.Errorf("%w: %s: %s", ErrStatus, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
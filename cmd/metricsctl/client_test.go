@@ -0,0 +1,69 @@
+package main
+
+import (
+	ctx "context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"metrics/internal/compress"
+	s "metrics/internal/service"
+)
+
+// fakeServer отвечает на /update/ и /value/ одной и той же метрикой
+func fakeServer(t *testing.T, calls *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(compress.GzipMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		met := &s.Metrics{}
+		if err := met.UnmarshalJSON(body); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if met.IsCounter() && met.Delta == nil {
+			total := int64(7)
+			met.Delta = &total
+		}
+		data, _ := met.MarshalJSON()
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write(data)
+	})))
+}
+
+func TestClientCommands(t *testing.T) {
+	var calls atomic.Int32
+	srv := fakeServer(t, &calls)
+	defer srv.Close()
+	cx := ctx.Background()
+
+	for name, args := range map[string][]string{
+		"inc": {"-a", srv.URL, "-o", "json", "PollCount", "2"},
+		"set": {"-a", srv.URL, "-o", "csv", "Alloc", "1.5"},
+		"get": {"-a", srv.URL, "counter", "PollCount"},
+	} {
+		if err := commands[name](cx, args); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("requests = %d, want 3", calls.Load())
+	}
+
+	// неверный формат отклоняется до запроса, счетчик не меняется
+	for _, name := range []string{"inc", "set", "get", "list", "ping"} {
+		err := commands[name](cx, []string{"-a", srv.URL, "-o", "yaml", "x", "1"})
+		if !errors.Is(err, ErrFormat) {
+			t.Errorf("%s -o yaml: %v, want ErrFormat", name, err)
+		}
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("request sent with invalid format: %d", calls.Load())
+	}
+}
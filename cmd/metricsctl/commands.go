@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	s "metrics/internal/service"
+)
+
+var ErrUsage = errors.New("usage")
+
+var metricColumns = []string{"type", "id", "value"}
+
+func formatMetric(met *s.Metrics) string {
+	switch {
+	case met.Delta != nil:
+		return strconv.FormatInt(*met.Delta, 10)
+	case met.Value != nil:
+		return strconv.FormatFloat(*met.Value, 'f', -1, 64)
+	}
+	return ""
+}
+
+func printMetric(cl *client, met *s.Metrics) error {
+	p, err := newPrinter(os.Stdout, cl.format, metricColumns...)
+	if err != nil {
+		return err
+	}
+	if err := p.row(met.MType, met.ID, formatMetric(met)); err != nil {
+		return err
+	}
+	return p.flush()
+}
+
+// getCmd: metricsctl get [flags] <type> <id>
+func getCmd(cx ctx.Context, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	newClient := clientFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("%w: metricsctl get [flags] <gauge|counter> <id>", ErrUsage)
+	}
+	met := &s.Metrics{MType: fs.Arg(0), ID: fs.Arg(1)}
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+	return cl.send(cx, "/value/", met)
+}
+
+// setCmd: metricsctl set [flags] <id> <value> - записывает gauge
+func setCmd(cx ctx.Context, args []string) error {
+	fs := flag.NewFlagSet("set", flag.ContinueOnError)
+	newClient := clientFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("%w: metricsctl set [flags] <id> <value>", ErrUsage)
+	}
+	met, err := s.NewMetric(s.Gauge, fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+	return cl.send(cx, "/update/", met)
+}
+
+// incCmd: metricsctl inc [flags] <id> [delta] - увеличивает counter, по умолчанию на 1
+func incCmd(cx ctx.Context, args []string) error {
+	fs := flag.NewFlagSet("inc", flag.ContinueOnError)
+	newClient := clientFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return fmt.Errorf("%w: metricsctl inc [flags] <id> [delta]", ErrUsage)
+	}
+	delta := "1"
+	if fs.NArg() == 2 {
+		delta = fs.Arg(1)
+	}
+	met, err := s.NewMetric(s.Counter, fs.Arg(0), delta)
+	if err != nil {
+		return err
+	}
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+	return cl.send(cx, "/update/", met)
+}
+
+func (cl *client) send(cx ctx.Context, path string, met *s.Metrics) error {
+	data, err := met.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	resp, err := cl.post(cx, path, data)
+	if err != nil {
+		return err
+	}
+	res := &s.Metrics{}
+	if err := res.UnmarshalJSON(resp); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return printMetric(cl, res)
+}
+
+type listItem struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	Updated string `json:"updated"`
+}
+
+// listCmd: metricsctl list [flags]
+func listCmd(cx ctx.Context, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	newClient := clientFlags(fs)
+	prefix := fs.String("prefix", "", "ID prefix filter arg: -prefix <str>")
+	mtype := fs.String("type", "", "Type filter arg: -type <gauge|counter>")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+	resp, err := cl.get(cx, "/", "application/json")
+	if err != nil {
+		return err
+	}
+	var items []listItem
+	if err := json.Unmarshal(resp, &items); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	p, err := newPrinter(os.Stdout, cl.format, "type", "id", "value", "updated")
+	if err != nil {
+		return err
+	}
+	for _, it := range items {
+		if !strings.HasPrefix(it.ID, *prefix) || (*mtype != "" && it.Type != *mtype) {
+			continue
+		}
+		if err := p.row(it.Type, it.ID, it.Value, it.Updated); err != nil {
+			return err
+		}
+	}
+	return p.flush()
+}
+
+// streamEvent повторяет поля события /stream; s.Metrics не встраивается,
+// иначе его UnmarshalJSON перехватит разбор и потеряет time.
+type streamEvent struct {
+	Delta *int64   `json:"delta,omitempty"`
+	Value *float64 `json:"value,omitempty"`
+	ID    string   `json:"id"`
+	MType string   `json:"type"`
+	Time  string   `json:"time"`
+}
+
+// watchCmd: metricsctl watch [flags] - печатает обновления из /stream до Ctrl+C
+func watchCmd(cx ctx.Context, args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	newClient := clientFlags(fs)
+	prefix := fs.String("prefix", "", "ID prefix filter arg: -prefix <str>")
+	mtype := fs.String("type", "", "Type filter arg: -type <gauge|counter>")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+	p, err := newPrinter(os.Stdout, cl.format, "time", "type", "id", "value")
+	if err != nil {
+		return err
+	}
+	query := url.Values{}
+	if *prefix != "" {
+		query.Set("prefix", *prefix)
+	}
+	if *mtype != "" {
+		query.Set("type", *mtype)
+	}
+	req, err := http.NewRequestWithContext(cx, http.MethodGet, cl.addr+"/stream?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := cl.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", ErrStatus, resp.Status)
+	}
+
+	var event string
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && event == "metric":
+			var ev streamEvent
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+				return fmt.Errorf("decode event: %w", err)
+			}
+			if err := p.row(ev.Time, ev.MType, ev.ID, formatMetric(&s.Metrics{Delta: ev.Delta, Value: ev.Value})); err != nil {
+				return err
+			}
+			if err := p.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := sc.Err(); err != nil && cx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// pingCmd: metricsctl ping [flags]
+func pingCmd(cx ctx.Context, args []string) error {
+	fs := flag.NewFlagSet("ping", flag.ContinueOnError)
+	newClient := clientFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cl, err := newClient()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(cx, http.MethodGet, cl.addr+"/ping", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := cl.http.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	p, err := newPrinter(os.Stdout, cl.format, "status", "circuit")
+	if err != nil {
+		return err
+	}
+	circuit := resp.Header.Get("X-Circuit-State")
+	if circuit == "" {
+		circuit = "-"
+	}
+	if err := p.row(resp.Status, circuit); err != nil {
+		return err
+	}
+	if err := p.flush(); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", ErrStatus, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	ctx "context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"metrics/internal/config"
+)
+
+type command func(cx ctx.Context, args []string) error
+
+var commands = map[string]command{
+	"get":     getCmd,
+	"set":     setCmd,
+	"inc":     incCmd,
+	"list":    listCmd,
+	"watch":   watchCmd,
+	"ping":    pingCmd,
+	"migrate": migrateCmd,
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "usage: metricsctl <%s> [flags]\n", strings.Join(names, "|"))
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	cx, complete := config.CompletionCtx()
+	defer complete()
+
+	if err := cmd(cx, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "metricsctl %s: %v\n", os.Args[1], err)
+		complete()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	ctx "context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"metrics/internal/config"
+	"metrics/internal/server"
+	s "metrics/internal/service"
+)
+
+const defaultMigrateBatch = 500
+
+var errNoStorage = errors.New("both -from and -to are required")
+
+func migrateCmd(cx ctx.Context, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	from := fs.String("from", "", "Source storage: -from <file:/path|kv:/path|sqlite:///path|postgres://...>")
+	to := fs.String("to", "", "Target storage: -to <file:/path|kv:/path|sqlite:///path|postgres://...>")
+	batch := fs.Int("batch", defaultMigrateBatch, "Metrics per PutBatch: -batch <n>")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return errNoStorage
+	}
+
+	src, err := config.NewStorage(cx, *from)
+	if err != nil {
+		return fmt.Errorf("open source: %w", err)
+	}
+	defer src.Close()
+	dst, err := config.NewStorage(cx, *to)
+	if err != nil {
+		return fmt.Errorf("open target: %w", err)
+	}
+	defer dst.Close()
+
+	n, err := migrate(cx, src, dst, *batch)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("migrated %d metrics from %s to %s\n", n, *from, *to)
+	return nil
+}
+
+type metricKey struct {
+	mtype, id string
+}
+
+// migrate копирует все метрики из src в dst. PutBatch складывает дельты
+// счетчиков, поэтому для счетчика пишется разница между итогами источника
+// и цели: повторный запуск не удваивает значения.
+func migrate(cx ctx.Context, src, dst server.Storage, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = defaultMigrateBatch
+	}
+	mets, err := src.List(cx)
+	if err != nil {
+		return 0, fmt.Errorf("list source: %w", err)
+	}
+	existing, err := dst.List(cx)
+	if err != nil {
+		return 0, fmt.Errorf("list target: %w", err)
+	}
+	totals := make(map[metricKey]int64, len(existing))
+	for _, m := range existing {
+		if m.IsCounter() && m.Delta != nil {
+			totals[metricKey{m.MType, m.ID}] = *m.Delta
+		}
+	}
+
+	batch := make([]*s.Metrics, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := dst.PutBatch(cx, batch); err != nil {
+			return fmt.Errorf("put batch: %w", err)
+		}
+		batch = make([]*s.Metrics, 0, batchSize)
+		return nil
+	}
+	for _, m := range mets {
+		out := &s.Metrics{ID: m.ID, MType: m.MType}
+		switch {
+		case m.IsCounter() && m.Delta != nil:
+			delta := *m.Delta - totals[metricKey{m.MType, m.ID}]
+			out.Delta = &delta
+		case m.IsGauge() && m.Value != nil:
+			val := *m.Value
+			out.Value = &val
+		default:
+			return 0, fmt.Errorf("%w: %s", s.ErrInvalidVal, m.ID)
+		}
+		batch = append(batch, out)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+	return len(mets), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	ctx "context"
+	"path/filepath"
+	"testing"
+
+	"metrics/internal/config"
+	"metrics/internal/server"
+	s "metrics/internal/service"
+)
+
+func TestMigrateKeepsCounterTotals(t *testing.T) {
+	cx := ctx.Background()
+	src, err := config.NewStorage(cx, "file:"+filepath.Join(t.TempDir(), "metrics.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	total, gauge := int64(10), 2.5
+	_ = src.PutBatch(cx, []*s.Metrics{
+		{ID: "PollCount", MType: s.Counter, Delta: &total},
+		{ID: "PollCount", MType: s.Gauge, Value: &gauge},
+	})
+
+	dst := server.NewMemStore()
+	partial := int64(3)
+	_, _ = dst.Put(cx, &s.Metrics{ID: "PollCount", MType: s.Counter, Delta: &partial})
+
+	for i := 0; i < 2; i++ {
+		n, err := migrate(cx, src, dst, 1)
+		if err != nil || n != 2 {
+			t.Fatalf("migrate = %d, %v", n, err)
+		}
+	}
+	c, err := dst.Get(cx, &s.Metrics{ID: "PollCount", MType: s.Counter})
+	if err != nil || *c.Delta != total {
+		t.Fatalf("counter = %v, %v; want %d", c, err, total)
+	}
+	g, err := dst.Get(cx, &s.Metrics{ID: "PollCount", MType: s.Gauge})
+	if err != nil || *g.Value != gauge {
+		t.Fatalf("gauge = %v, %v; want %v", g, err, gauge)
+	}
+}
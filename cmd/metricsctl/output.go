@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
+var ErrFormat = errors.New("unknown output format")
+
+// printer выводит строки одной таблицы. JSON печатается построчно (по
+// объекту на строку), чтобы watch можно было читать потоково.
+type printer struct {
+	format  string
+	columns []string
+	w       io.Writer
+	tw      *tabwriter.Writer
+	csv     *csv.Writer
+	header  bool
+}
+
+func checkFormat(format string) error {
+	switch format {
+	case formatTable, formatJSON, formatCSV:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrFormat, format)
+}
+
+func newPrinter(w io.Writer, format string, columns ...string) (*printer, error) {
+	p := &printer{format: format, columns: columns, w: w}
+	switch format {
+	case formatTable:
+		p.tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	case formatCSV:
+		p.csv = csv.NewWriter(w)
+	case formatJSON:
+	default:
+		return nil, checkFormat(format)
+	}
+	return p, nil
+}
+
+func (p *printer) row(values ...string) error {
+	switch p.format {
+	case formatJSON:
+		obj := make(map[string]string, len(p.columns))
+		for i, col := range p.columns {
+			obj[col] = values[i]
+		}
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.w, "%s\n", data)
+		return err
+	case formatCSV:
+		if !p.header {
+			p.header = true
+			if err := p.csv.Write(p.columns); err != nil {
+				return err
+			}
+		}
+		return p.csv.Write(values)
+	default:
+		if !p.header {
+			p.header = true
+			if _, err := fmt.Fprintln(p.tw, strings.ToUpper(strings.Join(p.columns, "\t"))); err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintln(p.tw, strings.Join(values, "\t"))
+		return err
+	}
+}
+
+func (p *printer) flush() error {
+	switch p.format {
+	case formatCSV:
+		p.csv.Flush()
+		return p.csv.Error()
+	case formatTable:
+		return p.tw.Flush()
+	}
+	return nil
+}
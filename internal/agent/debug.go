@@ -0,0 +1,225 @@
+package agent
+
+import (
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"metrics/internal/logger"
+	s "metrics/internal/service"
+
+	"go.uber.org/zap"
+)
+
+const staleReports = 3 // сколько интервалов отчета без успеха считается сбоем
+
+type workerStats struct {
+	sent     atomic.Int64
+	retries  atomic.Int64
+	failures atomic.Int64
+}
+
+// agentStats - состояние отправки, которое видно через отладочный HTTP и
+// (при ReportSelf) отправляется на сервер вместе с метриками.
+type agentStats struct {
+	started    time.Time
+	lastReport atomic.Int64 // unix nano последнего успешного отчета
+	workers    []*workerStats
+	// claimed - итоги, уже включенные в отчеты; lost - дельты из
+	// недоставленных отчетов, которые нужно отправить повторно
+	mtx     sync.Mutex
+	claimed map[string]int64
+	lost    map[string]int64
+}
+
+// report - отчет для отправки; self - дельты собственных счетчиков
+// агента в нем.
+type report struct {
+	data []byte
+	self map[string]int64
+}
+
+func newAgentStats(workers int) *agentStats {
+	st := &agentStats{
+		started: time.Now(),
+		workers: make([]*workerStats, workers),
+		claimed: make(map[string]int64),
+		lost:    make(map[string]int64),
+	}
+	for i := range st.workers {
+		st.workers[i] = &workerStats{}
+	}
+	return st
+}
+
+func (st *agentStats) totals() (sent, retries, failures int64) {
+	for _, w := range st.workers {
+		sent += w.sent.Load()
+		retries += w.retries.Load()
+		failures += w.failures.Load()
+	}
+	return
+}
+
+func (st *agentStats) lastReportTime() time.Time {
+	if ns := st.lastReport.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// selfMetrics возвращает дельты счетчиков, еще не включенные в отчеты
+// (вместе с дельтами недоставленных отчетов), и глубину очереди.
+func (sm *SelfMonitor) selfMetrics() ([]*s.Metrics, map[string]int64) {
+	sent, retries, failures := sm.stats.totals()
+	res := make([]*s.Metrics, 0, 4)
+	deltas := make(map[string]int64, 3)
+	sm.stats.mtx.Lock()
+	for name, total := range map[string]int64{
+		"AgentReportsSent":    sent,
+		"AgentReportRetries":  retries,
+		"AgentReportFailures": failures,
+	} {
+		delta := total - sm.stats.claimed[name] + sm.stats.lost[name]
+		sm.stats.claimed[name] = total
+		delete(sm.stats.lost, name)
+		deltas[name] = delta
+		res = append(res, &s.Metrics{ID: name, MType: s.Counter, Delta: &delta})
+	}
+	sm.stats.mtx.Unlock()
+	depth := float64(len(sm.dataCh))
+	res = append(res, &s.Metrics{ID: "AgentQueueDepth", MType: s.Gauge, Value: &depth})
+	return res, deltas
+}
+
+// unreport возвращает дельты недоставленного отчета в следующий.
+func (st *agentStats) unreport(deltas map[string]int64) {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	for name, delta := range deltas {
+		st.lost[name] += delta
+	}
+}
+
+type healthResponse struct {
+	Status     string    `json:"status"`
+	LastReport time.Time `json:"last_report"`
+	Uptime     string    `json:"uptime"`
+}
+
+type workerResponse struct {
+	ID       int   `json:"id"`
+	Sent     int64 `json:"sent"`
+	Retries  int64 `json:"retries"`
+	Failures int64 `json:"failures"`
+}
+
+type statsResponse struct {
+	LastReport    time.Time        `json:"last_report"`
+	QueueDepth    int              `json:"queue_depth"`
+	QueueCapacity int              `json:"queue_capacity"`
+	Workers       []workerResponse `json:"workers"`
+}
+
+type configResponse struct {
+	Address        string `json:"address"`
+	PollInterval   string `json:"poll_interval"`
+	ReportInterval string `json:"report_interval"`
+	Rate           int    `json:"rate"`
+	SignKey        bool   `json:"sign_key"`
+	ReportSelf     bool   `json:"report_self"`
+}
+
+func (sm *SelfMonitor) healthHandler(rw http.ResponseWriter, _ *http.Request) {
+	last := sm.stats.lastReportTime()
+	since := last
+	if since.IsZero() {
+		since = sm.stats.started
+	}
+	resp := healthResponse{
+		Status:     "ok",
+		LastReport: last,
+		Uptime:     time.Since(sm.stats.started).Round(time.Second).String(),
+	}
+	status := http.StatusOK
+	if time.Since(since) > staleReports*sm.ReportInterval {
+		resp.Status = "stale"
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(rw, status, resp)
+}
+
+func (sm *SelfMonitor) statsHandler(rw http.ResponseWriter, _ *http.Request) {
+	resp := statsResponse{
+		LastReport:    sm.stats.lastReportTime(),
+		QueueDepth:    len(sm.dataCh),
+		QueueCapacity: cap(sm.dataCh),
+		Workers:       make([]workerResponse, len(sm.stats.workers)),
+	}
+	for i, w := range sm.stats.workers {
+		resp.Workers[i] = workerResponse{
+			ID:       i,
+			Sent:     w.sent.Load(),
+			Retries:  w.retries.Load(),
+			Failures: w.failures.Load(),
+		}
+	}
+	writeJSON(rw, http.StatusOK, resp)
+}
+
+func (sm *SelfMonitor) configHandler(rw http.ResponseWriter, _ *http.Request) {
+	writeJSON(rw, http.StatusOK, configResponse{
+		Address:        sm.Address,
+		PollInterval:   sm.PollInterval.String(),
+		ReportInterval: sm.ReportInterval.String(),
+		Rate:           sm.Rate,
+		SignKey:        sm.Key != "", // сам ключ не показываем
+		ReportSelf:     sm.ReportSelf,
+	})
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_, _ = rw.Write(data)
+}
+
+func (sm *SelfMonitor) debugMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", sm.healthHandler)
+	mux.HandleFunc("/stats", sm.statsHandler)
+	mux.HandleFunc("/config", sm.configHandler)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// serveDebug поднимает отладочный HTTP на DebugAddress до отмены cx.
+func (sm *SelfMonitor) serveDebug(cx ctx.Context) {
+	srv := &http.Server{
+		Addr:              sm.DebugAddress,
+		Handler:           sm.debugMux(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-cx.Done()
+		_ = srv.Close()
+	}()
+	logger.Info("agent debug endpoint", zap.String("addr", sm.DebugAddress))
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Warn("agent debug endpoint error", zap.Error(err))
+	}
+}
@@ -0,0 +1,92 @@
+package agent
+
+import (
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+func TestDebugEndpoints(t *testing.T) {
+	sm := NewSelfMonitor()
+	sm.Rate = 2
+	sm.ReportInterval = time.Second
+	sm.dataCh = make(chan report, sm.Rate)
+	sm.stats = newAgentStats(sm.Rate)
+	sm.stats.workers[1].retries.Add(3)
+	sm.stats.workers[1].failures.Add(1)
+	sm.dataCh <- report{data: []byte("{}")}
+	srv := httptest.NewServer(sm.debugMux())
+	defer srv.Close()
+
+	var stats statsResponse
+	resp, err := http.Get(srv.URL + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&stats)
+	resp.Body.Close()
+	if stats.QueueDepth != 1 || stats.QueueCapacity != 2 || stats.Workers[1].Retries != 3 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	resp, _ = http.Get(srv.URL + "/healthz")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("fresh agent health = %d", resp.StatusCode)
+	}
+	sm.stats.started = time.Now().Add(-staleReports * 2 * sm.ReportInterval)
+	resp, _ = http.Get(srv.URL + "/healthz")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("stale agent health = %d", resp.StatusCode)
+	}
+
+	mets, deltas := sm.selfMetrics()
+	for _, m := range mets {
+		if m.ID == "AgentReportRetries" && *m.Delta != 3 {
+			t.Fatalf("retries delta = %d", *m.Delta)
+		}
+	}
+	// недоставленный отчет: его дельты уходят в следующий
+	sm.stats.workers[0].failures.Add(1)
+	sm.stats.unreport(deltas)
+	mets, _ = sm.selfMetrics()
+	for _, m := range mets {
+		if m.ID == "AgentReportRetries" && *m.Delta != 3 ||
+			m.ID == "AgentReportFailures" && *m.Delta != 2 {
+			t.Fatalf("%s delta after failed report = %d", m.ID, *m.Delta)
+		}
+	}
+	mets, _ = sm.selfMetrics()
+	for _, m := range mets {
+		if m.IsCounter() && *m.Delta != 0 {
+			t.Fatalf("%s delta after report = %d", m.ID, *m.Delta)
+		}
+	}
+}
+
+func TestSendRejectedIsPermanent(t *testing.T) {
+	code := http.StatusBadRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(code)
+	}))
+	defer srv.Close()
+
+	sm := NewSelfMonitor()
+	var permanent *backoff.PermanentError
+	err := sm.send(ctx.Background(), srv.URL, nil, nil)
+	if !errors.Is(err, ErrReportStatus) || !errors.As(err, &permanent) {
+		t.Fatalf("400 must not be retried: %v", err)
+	}
+	code = http.StatusServiceUnavailable
+	err = sm.send(ctx.Background(), srv.URL, nil, nil)
+	if !errors.Is(err, ErrReportStatus) || errors.As(err, &permanent) {
+		t.Fatalf("503 must be retried: %v", err)
+	}
+}
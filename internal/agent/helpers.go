@@ -3,20 +3,28 @@ package agent
 import (
 	"bytes"
 	ctx "context"
+	"errors"
+	"fmt"
 	"net/http"
 	"runtime"
 	"sync"
+	"time"
 
 	"metrics/internal/compress"
 	"metrics/internal/logger"
 	"metrics/internal/security"
 	s "metrics/internal/service"
+	"metrics/internal/tracing"
 
+	"github.com/cenkalti/backoff/v4"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
 const numMemMetrics = 31
 
+var ErrReportStatus = errors.New("unexpected report response status")
+
 var (
 	numAllMetrics = runtime.NumCPU() + numMemMetrics
 	randVal       float64
@@ -31,42 +39,81 @@ func NewSelfMonitor() *SelfMonitor {
 }
 
 func (sm *SelfMonitor) sendWorker(cx ctx.Context,
+	n int,
 	url string,
-	dataCh <-chan []byte,
+	dataCh <-chan report,
 	wg *sync.WaitGroup,
 ) {
-	for data := range dataCh {
+	ws := sm.stats.workers[n]
+	for rep := range dataCh {
+		data := rep.data
 		compressData, _ := compress.Compress(data)
 
-		req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(compressData))
-		if sm.Key != "" {
-			sign := security.Hash(&data, sm.Key)
-			req.Header.Set("HashSHA256", sign)
-		}
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Content-Encoding", "gzip")
-
 		logger.Debug("REPORT...")
-		r, err := http.DefaultClient.Do(req)
-		if err != nil {
-			_ = s.Retry(cx, func() error {
-				r2, retErr := http.DefaultClient.Do(req)
-				closeBody(r2)
+		scx, span := tracing.Start(cx, "agent.report",
+			attribute.Int("worker", n),
+			attribute.Int("bytes", len(data)))
+		err := sm.send(scx, url, data, compressData)
+		var permanent *backoff.PermanentError
+		if err != nil && !errors.As(err, &permanent) {
+			err = s.Retry(cx, func() error {
+				ws.retries.Add(1)
+				retErr := sm.send(scx, url, data, compressData)
 				logger.Warn("retry result", zap.Error(retErr))
 				return retErr
 			})
-		} else {
-			logger.Debug("success report!")
-			sm.cond.L.Lock()
-			pollCount = 0
-			sm.cond.L.Unlock()
 		}
-		closeBody(r)
+		tracing.End(span, err)
+		if err != nil {
+			ws.failures.Add(1)
+			sm.stats.unreport(rep.self)
+			continue
+		}
+		logger.Debug("success report!")
+		ws.sent.Add(1)
+		sm.stats.lastReport.Store(time.Now().UnixNano())
+		sm.cond.L.Lock()
+		pollCount = 0
+		sm.cond.L.Unlock()
 	}
 	wg.Done()
 	logger.Debug("goodbye from sendWorker")
 }
 
+// send делает одну попытку отчета; запрос собирается заново, так как тело
+// прошлой попытки уже прочитано. cx несет только trace context.
+func (sm *SelfMonitor) send(cx ctx.Context, url string, data, compressData []byte) error {
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(compressData))
+	tracing.Inject(cx, req.Header)
+	if sm.Key != "" {
+		sign := security.Hash(&data, sm.Key)
+		req.Header.Set("HashSHA256", sign)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	closeBody(r)
+	if r.StatusCode < http.StatusMultipleChoices {
+		return nil
+	}
+	err = fmt.Errorf("%w: %s", ErrReportStatus, r.Status)
+	if retryableStatus(r.StatusCode) {
+		return err
+	}
+	// повтор не поможет: отчет отклонен
+	return backoff.Permanent(err)
+}
+
+func retryableStatus(code int) bool {
+	return code >= http.StatusInternalServerError ||
+		code == http.StatusTooManyRequests ||
+		code == http.StatusRequestTimeout
+}
+
 func closeBody(r *http.Response) {
 	if r != nil && r.Body != nil {
 		r.Body.Close()
@@ -10,6 +10,7 @@ import (
 
 	"metrics/internal/logger"
 	s "metrics/internal/service"
+	"metrics/internal/tracing"
 
 	"github.com/pquerna/ffjson/ffjson"
 	"github.com/shirou/gopsutil/v4/cpu"
@@ -26,7 +27,11 @@ type SelfMonitor struct {
 	PollInterval   time.Duration
 	ReportInterval time.Duration
 	Rate           int
+	DebugAddress   string
+	ReportSelf     bool
 	finish         bool
+	dataCh         chan report
+	stats          *agentStats
 }
 
 func (sm *SelfMonitor) collectRuntime(wg *sync.WaitGroup) {
@@ -105,11 +110,10 @@ func (sm *SelfMonitor) report(cx ctx.Context, wg *sync.WaitGroup) {
 	url := "http://" + sm.Address + "/updates/"
 	defer wg.Done()
 
-	dataCh := make(chan []byte, sm.Rate)
-	defer close(dataCh)
+	defer close(sm.dataCh)
 	wg.Add(sm.Rate)
 	for i := 0; i < sm.Rate; i++ {
-		go sm.sendWorker(cx, url, dataCh, wg)
+		go sm.sendWorker(cx, i, url, sm.dataCh, wg)
 	}
 	reportTick := time.NewTicker(sm.ReportInterval)
 	defer reportTick.Stop()
@@ -117,9 +121,22 @@ func (sm *SelfMonitor) report(cx ctx.Context, wg *sync.WaitGroup) {
 		select {
 		case <-reportTick.C:
 			sm.cond.L.Lock()
-			data, _ := ffjson.Marshal(mets)
+			// до первого опроса часть метрик еще не собрана
+			batch := make([]*s.Metrics, 0, len(mets)+4)
+			for _, m := range mets {
+				if m != nil {
+					batch = append(batch, m)
+				}
+			}
+			var self map[string]int64
+			if sm.ReportSelf {
+				var selfMets []*s.Metrics
+				selfMets, self = sm.selfMetrics()
+				batch = append(batch, selfMets...)
+			}
+			data, _ := ffjson.Marshal(batch)
 			sm.cond.L.Unlock()
-			dataCh <- data
+			sm.dataCh <- report{data: data, self: self}
 		case <-cx.Done():
 			logger.Debug("goodbye from report...")
 			return
@@ -128,6 +145,12 @@ func (sm *SelfMonitor) report(cx ctx.Context, wg *sync.WaitGroup) {
 }
 
 func (sm *SelfMonitor) Run(cx ctx.Context) {
+	sm.dataCh = make(chan report, sm.Rate)
+	sm.stats = newAgentStats(sm.Rate)
+	if sm.DebugAddress != "" {
+		go sm.serveDebug(cx)
+	}
+	defer tracing.Shutdown() // после wg.Wait: спаны последних отчетов закрыты
 	wg := new(sync.WaitGroup)
 	defer wg.Wait()
 	wg.Add(numOfGorutines)
@@ -0,0 +1,160 @@
+package alert
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	s "metrics/internal/service"
+)
+
+type State string
+
+const (
+	StateInactive State = "inactive"
+	StatePending  State = "pending"
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+type Alert struct {
+	ActiveAt   time.Time `json:"activeAt"`
+	FiredAt    time.Time `json:"firedAt"`
+	ResolvedAt time.Time `json:"resolvedAt"`
+	Rule       string    `json:"rule"`
+	Expr       string    `json:"expr"`
+	State      State     `json:"state"`
+	Value      float64   `json:"value"`
+}
+
+// Key различает gauge и counter с одинаковым id.
+type Key struct {
+	Type string
+	ID   string
+}
+
+// Values - снимок значений метрик.
+type Values map[Key]float64
+
+type sample struct {
+	at  time.Time
+	val float64
+}
+
+type ruleState struct {
+	rule  *Rule
+	alert Alert
+	last  *sample
+}
+
+// Engine вычисляет правила по снимку значений метрик и хранит
+// состояние каждого правила между вызовами Eval.
+type Engine struct {
+	mtx    sync.RWMutex
+	states []*ruleState
+}
+
+func NewEngine(rules []*Rule) *Engine {
+	states := make([]*ruleState, len(rules))
+	for i, r := range rules {
+		states[i] = &ruleState{
+			rule:  r,
+			alert: Alert{Rule: r.Name, Expr: r.String(), State: StateInactive},
+		}
+	}
+	return &Engine{states: states}
+}
+
+// Eval применяет правила к снимку values и возвращает алерты, перешедшие
+// в состояние firing или resolved на этом шаге.
+func (e *Engine) Eval(now time.Time, values Values) []Alert {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	var changed []Alert
+	for _, st := range e.states {
+		val, ok := st.value(now, values)
+		a := &st.alert
+		if ok {
+			a.Value = val
+		}
+		if ok && st.rule.holds(val) {
+			switch a.State {
+			case StateInactive, StateResolved:
+				a.State = StatePending
+				a.ActiveAt = now
+				a.FiredAt = time.Time{}
+				a.ResolvedAt = time.Time{}
+			}
+			if a.State == StatePending && now.Sub(a.ActiveAt) >= st.rule.For {
+				a.State = StateFiring
+				a.FiredAt = now
+				changed = append(changed, *a)
+			}
+			continue
+		}
+		switch a.State {
+		case StateFiring:
+			a.State = StateResolved
+			a.ResolvedAt = now
+			changed = append(changed, *a)
+		case StatePending:
+			a.State = StateInactive
+		}
+	}
+	return changed
+}
+
+func (st *ruleState) value(now time.Time, values Values) (float64, bool) {
+	val, ok := st.rule.lookup(values)
+	if !ok {
+		st.last = nil
+		return 0, false
+	}
+	if !st.rule.Rate {
+		return val, true
+	}
+	prev := st.last
+	st.last = &sample{at: now, val: val}
+	if prev == nil {
+		return 0, false
+	}
+	dt := now.Sub(prev.at).Seconds()
+	if dt <= 0 {
+		return 0, false
+	}
+	delta := val - prev.val
+	if delta < 0 { // сброс счетчика
+		delta = val
+	}
+	return delta / dt, true
+}
+
+func (r *Rule) lookup(values Values) (float64, bool) {
+	if r.MetricType != "" {
+		val, ok := values[Key{Type: r.MetricType, ID: r.MetricID}]
+		return val, ok
+	}
+	for _, mtype := range []string{s.Gauge, s.Counter} {
+		if val, ok := values[Key{Type: mtype, ID: r.MetricID}]; ok {
+			return val, true
+		}
+	}
+	return 0, false
+}
+
+// Active возвращает алерты в состояниях pending и firing.
+func (e *Engine) Active() []Alert {
+	e.mtx.RLock()
+	alerts := make([]Alert, 0, len(e.states))
+	for _, st := range e.states {
+		if st.alert.State == StatePending || st.alert.State == StateFiring {
+			alerts = append(alerts, st.alert)
+		}
+	}
+	e.mtx.RUnlock()
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].Rule < alerts[j].Rule
+	})
+	return alerts
+}
@@ -0,0 +1,192 @@
+package alert
+
+import (
+	"bytes"
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "metrics/internal/logger"
+	"metrics/internal/security"
+
+	"github.com/cenkalti/backoff/v4"
+	"go.uber.org/zap"
+)
+
+var ErrWebhook = errors.New("webhook delivery error")
+
+type Webhook struct {
+	URL string
+	Key string
+}
+
+// ParseWebhooks разбирает список вида "url[#key],url[#key]".
+func ParseWebhooks(list string) ([]Webhook, error) {
+	var hooks []Webhook
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		addr, key, _ := strings.Cut(item, "#")
+		if u, err := url.Parse(addr); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("%w: bad url %q", ErrWebhook, addr)
+		}
+		hooks = append(hooks, Webhook{URL: addr, Key: key})
+	}
+	return hooks, nil
+}
+
+type Payload struct {
+	SentAt time.Time `json:"sentAt"`
+	Rule   string    `json:"rule"`
+	Alerts []Alert   `json:"alerts"`
+}
+
+type Notifier struct {
+	Client *http.Client
+	// NewBackOff задает политику повторов для одной доставки
+	NewBackOff func() backoff.BackOff
+	sent       map[string]time.Time
+	inflight   map[string]struct{}
+	hooks      []Webhook
+	mtx        sync.Mutex
+	dedup      time.Duration
+}
+
+func NewNotifier(hooks []Webhook, dedup time.Duration) *Notifier {
+	return &Notifier{
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		NewBackOff: defaultBackOff,
+		sent:       make(map[string]time.Time),
+		inflight:   make(map[string]struct{}),
+		hooks:      hooks,
+		dedup:      dedup,
+	}
+}
+
+func defaultBackOff() backoff.BackOff {
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.InitialInterval = 1 * time.Second
+	expBackoff.Multiplier = 3
+	expBackoff.MaxInterval = 10 * time.Second
+	expBackoff.MaxElapsedTime = time.Minute
+	return expBackoff
+}
+
+// Notify группирует алерты по правилу, отбрасывает повторы внутри окна
+// дедупликации и рассылает каждую группу на все вебхуки. Алерт считается
+// отправленным, только если его принял хотя бы один вебхук: иначе повтор
+// того же алерта не подавляется.
+func (n *Notifier) Notify(cx ctx.Context, alerts []Alert) error {
+	var errs []error
+	for _, p := range n.group(alerts) {
+		body, err := json.Marshal(p)
+		if err != nil {
+			n.settle(p, false)
+			errs = append(errs, fmt.Errorf("notify marshal: %w", err))
+			continue
+		}
+		delivered := false
+		for _, hook := range n.hooks {
+			if err := n.deliver(cx, hook, body); err != nil {
+				log.Warn("webhook delivery failed",
+					zap.String("url", hook.URL),
+					zap.String("rule", p.Rule),
+					zap.Error(err))
+				errs = append(errs, err)
+				continue
+			}
+			delivered = true
+		}
+		n.settle(p, delivered)
+	}
+	return errors.Join(errs...)
+}
+
+func alertKey(a Alert) string {
+	return a.Rule + "/" + string(a.State)
+}
+
+func (n *Notifier) group(alerts []Alert) []*Payload {
+	now := time.Now()
+	groups := make(map[string]*Payload)
+	n.mtx.Lock()
+	for key, at := range n.sent {
+		if now.Sub(at) >= n.dedup {
+			delete(n.sent, key)
+		}
+	}
+	for _, a := range alerts {
+		key := alertKey(a)
+		_, dup := n.sent[key]
+		if _, sending := n.inflight[key]; dup || sending {
+			continue
+		}
+		n.inflight[key] = struct{}{}
+		p, ok := groups[a.Rule]
+		if !ok {
+			p = &Payload{SentAt: now, Rule: a.Rule}
+			groups[a.Rule] = p
+		}
+		p.Alerts = append(p.Alerts, a)
+	}
+	n.mtx.Unlock()
+
+	payloads := make([]*Payload, 0, len(groups))
+	for _, p := range groups {
+		payloads = append(payloads, p)
+	}
+	sort.Slice(payloads, func(i, j int) bool {
+		return payloads[i].Rule < payloads[j].Rule
+	})
+	return payloads
+}
+
+// settle снимает отметку об отправке; время отправки запоминается для
+// дедупликации только при успешной доставке.
+func (n *Notifier) settle(p *Payload, delivered bool) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	for _, a := range p.Alerts {
+		key := alertKey(a)
+		delete(n.inflight, key)
+		if delivered {
+			n.sent[key] = p.SentAt
+		}
+	}
+}
+
+func (n *Notifier) deliver(cx ctx.Context, hook Webhook, body []byte) error {
+	send := func() error {
+		req, err := http.NewRequestWithContext(cx, http.MethodPost, hook.URL, bytes.NewReader(body))
+		if err != nil {
+			return backoff.Permanent(fmt.Errorf("%w: %w", ErrWebhook, err))
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if hook.Key != "" {
+			req.Header.Set("HashSHA256", security.Hash(&body, hook.Key))
+		}
+		resp, err := n.Client.Do(req)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrWebhook, err)
+		}
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+			return fmt.Errorf("%w: status %d", ErrWebhook, resp.StatusCode)
+		default:
+			return backoff.Permanent(fmt.Errorf("%w: status %d", ErrWebhook, resp.StatusCode))
+		}
+	}
+	return backoff.Retry(send, backoff.WithContext(n.NewBackOff(), cx))
+}
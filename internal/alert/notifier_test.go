@@ -0,0 +1,124 @@
+package alert
+
+import (
+	ctx "context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"metrics/internal/security"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+type webhookRecorder struct {
+	mtx      sync.Mutex
+	payloads []Payload
+	fails    int
+	key      string
+	t        *testing.T
+}
+
+func (wr *webhookRecorder) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	wr.mtx.Lock()
+	defer wr.mtx.Unlock()
+	if wr.fails > 0 {
+		wr.fails--
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(req.Body)
+	if sign := security.Hash(&body, wr.key); req.Header.Get("HashSHA256") != sign {
+		wr.t.Errorf("bad signature %q, want %q", req.Header.Get("HashSHA256"), sign)
+	}
+	var p Payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		wr.t.Errorf("bad payload: %v", err)
+	}
+	wr.payloads = append(wr.payloads, p)
+	rw.WriteHeader(http.StatusOK)
+}
+
+func newTestNotifier(url, key string) *Notifier {
+	n := NewNotifier([]Webhook{{URL: url, Key: key}}, time.Minute)
+	n.NewBackOff = func() backoff.BackOff {
+		return backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 3)
+	}
+	return n
+}
+
+func TestNotifierGroupsAndSigns(t *testing.T) {
+	rec := &webhookRecorder{key: "secret", fails: 2, t: t}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	n := newTestNotifier(srv.URL, rec.key)
+	alerts := []Alert{
+		{Rule: "cpu", State: StateFiring, Value: 95},
+		{Rule: "heap", State: StateFiring, Value: 1},
+		{Rule: "cpu", State: StateResolved, Value: 10},
+	}
+	if err := n.Notify(ctx.Background(), alerts); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	if len(rec.payloads) != 2 {
+		t.Fatalf("expected 2 grouped payloads, got %d", len(rec.payloads))
+	}
+	if rec.payloads[0].Rule != "cpu" || len(rec.payloads[0].Alerts) != 2 {
+		t.Errorf("unexpected cpu group: %+v", rec.payloads[0])
+	}
+}
+
+func TestNotifierDedup(t *testing.T) {
+	rec := &webhookRecorder{key: "k", t: t}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	n := newTestNotifier(srv.URL, rec.key)
+	firing := []Alert{{Rule: "cpu", State: StateFiring}}
+	_ = n.Notify(ctx.Background(), firing)
+	_ = n.Notify(ctx.Background(), firing)
+	if len(rec.payloads) != 1 {
+		t.Fatalf("expected duplicate to be suppressed, got %d payloads", len(rec.payloads))
+	}
+}
+
+func TestNotifierPermanentError(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	n := newTestNotifier(srv.URL, "")
+	if err := n.Notify(ctx.Background(), []Alert{{Rule: "cpu", State: StateFiring}}); err == nil {
+		t.Fatal("expected delivery error")
+	}
+	if calls != 1 {
+		t.Errorf("4xx must not be retried, got %d calls", calls)
+	}
+}
+
+func TestNotifierRetriesUndelivered(t *testing.T) {
+	rec := &webhookRecorder{key: "k", fails: 4, t: t}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	n := newTestNotifier(srv.URL, rec.key)
+	firing := []Alert{{Rule: "cpu", State: StateFiring}}
+	if err := n.Notify(ctx.Background(), firing); err == nil {
+		t.Fatal("expected delivery error")
+	}
+	// неудачная рассылка не попадает в окно дедупликации
+	if err := n.Notify(ctx.Background(), firing); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	if len(rec.payloads) != 1 {
+		t.Fatalf("expected the alert to be delivered on retry, got %d payloads", len(rec.payloads))
+	}
+}
@@ -0,0 +1,167 @@
+package alert
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	s "metrics/internal/service"
+)
+
+var ErrInvalidRule = errors.New("invalid alert rule")
+
+type operator string
+
+const (
+	opGT operator = ">"
+	opGE operator = ">="
+	opLT operator = "<"
+	opLE operator = "<="
+	opEQ operator = "=="
+	opNE operator = "!="
+)
+
+// порядок важен: двухсимвольные операторы проверяются первыми
+var operators = []operator{opGE, opLE, opEQ, opNE, opGT, opLT}
+
+// Rule проверяет одну метрику. MetricType задается префиксом
+// (counter:PollCount); без него берется gauge, а если его нет - counter.
+type Rule struct {
+	Name       string
+	Expr       string
+	MetricID   string
+	MetricType string
+	Rate       bool
+	Op         operator
+	Threshold  float64
+	For        time.Duration
+}
+
+func (r *Rule) String() string {
+	expr := fmt.Sprintf("%s %s %g", r.Expr, r.Op, r.Threshold)
+	if r.For > 0 {
+		expr += " for " + r.For.String()
+	}
+	return expr
+}
+
+func (r *Rule) holds(val float64) bool {
+	switch r.Op {
+	case opGT:
+		return val > r.Threshold
+	case opGE:
+		return val >= r.Threshold
+	case opLT:
+		return val < r.Threshold
+	case opLE:
+		return val <= r.Threshold
+	case opEQ:
+		return val == r.Threshold
+	default:
+		return val != r.Threshold
+	}
+}
+
+// ParseRule разбирает строку вида
+// "[name:] <[type:]id|rate([type:]id)> <op> <threshold> [for <duration>]".
+func ParseRule(line string) (*Rule, error) {
+	rule := &Rule{}
+	body := strings.TrimSpace(line)
+	if name, rest, ok := strings.Cut(body, ":"); ok && !strings.ContainsAny(name, "<>=!()") && !isTypePrefix(name, rest) {
+		rule.Name = strings.TrimSpace(name)
+		body = strings.TrimSpace(rest)
+	}
+	if cond, dur, ok := strings.Cut(body, " for "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(dur))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("%w %q: bad duration", ErrInvalidRule, line)
+		}
+		rule.For = d
+		body = strings.TrimSpace(cond)
+	}
+	for _, op := range operators {
+		lhs, rhs, ok := strings.Cut(body, string(op))
+		if !ok {
+			continue
+		}
+		threshold, err := strconv.ParseFloat(strings.TrimSpace(rhs), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: bad threshold", ErrInvalidRule, line)
+		}
+		rule.Op = op
+		rule.Threshold = threshold
+		rule.Expr = strings.TrimSpace(lhs)
+		break
+	}
+	if rule.Op == "" {
+		return nil, fmt.Errorf("%w %q: no comparison operator", ErrInvalidRule, line)
+	}
+	rule.MetricID = rule.Expr
+	if inner, ok := strings.CutPrefix(rule.Expr, "rate("); ok {
+		inner, ok = strings.CutSuffix(inner, ")")
+		if !ok {
+			return nil, fmt.Errorf("%w %q: unbalanced rate()", ErrInvalidRule, line)
+		}
+		rule.Rate = true
+		rule.MetricID = strings.TrimSpace(inner)
+	}
+	for _, mtype := range []string{s.Gauge, s.Counter} {
+		if id, ok := strings.CutPrefix(rule.MetricID, mtype+":"); ok {
+			rule.MetricType, rule.MetricID = mtype, id
+		}
+	}
+	if rule.MetricID == "" || strings.ContainsAny(rule.MetricID, " ()") {
+		return nil, fmt.Errorf("%w %q: bad metric id", ErrInvalidRule, line)
+	}
+	if rule.Name == "" {
+		rule.Name = rule.String()
+	}
+	return rule, nil
+}
+
+// isTypePrefix отличает тип метрики (counter:PollCount) от имени правила:
+// после имени идет пробел.
+func isTypePrefix(name, rest string) bool {
+	return (name == s.Gauge || name == s.Counter) && rest != "" && rest[0] != ' ' && rest[0] != '\t'
+}
+
+// ReadRules читает по одному правилу на строку, пустые строки и
+// комментарии (#) пропускаются.
+func ReadRules(r io.Reader) ([]*Rule, error) {
+	var rules []*Rule
+	names := make(map[string]struct{})
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := ParseRule(line)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := names[rule.Name]; dup {
+			return nil, fmt.Errorf("%w: duplicate rule name %q", ErrInvalidRule, rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+		rules = append(rules, rule)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read rules: %w", err)
+	}
+	return rules, nil
+}
+
+func LoadRules(path string) ([]*Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("load rules: %w", err)
+	}
+	defer f.Close()
+	return ReadRules(f)
+}
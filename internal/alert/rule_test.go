@@ -0,0 +1,120 @@
+package alert
+
+import (
+	"testing"
+	"time"
+
+	s "metrics/internal/service"
+)
+
+var heapAlloc = Key{Type: s.Gauge, ID: "HeapAlloc"}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		rule    Rule
+		wantErr bool
+	}{
+		{
+			name: "gauge threshold with duration",
+			line: "CPUutilization1 > 90 for 2m",
+			rule: Rule{MetricID: "CPUutilization1", Op: opGT, Threshold: 90, For: 2 * time.Minute},
+		},
+		{
+			name: "named rate rule",
+			line: "Stalled: rate(PollCount) == 0 for 1m",
+			rule: Rule{Name: "Stalled", MetricID: "PollCount", Rate: true, Op: opEQ, For: time.Minute},
+		},
+		{
+			name: "two-char operator",
+			line: "HeapAlloc >= 1e6",
+			rule: Rule{MetricID: "HeapAlloc", Op: opGE, Threshold: 1e6},
+		},
+		{
+			name: "typed metric",
+			line: "counter:Requests > 5",
+			rule: Rule{MetricID: "Requests", MetricType: s.Counter, Op: opGT, Threshold: 5},
+		},
+		{
+			name: "named typed rate",
+			line: "Busy: rate(counter:Requests) > 5",
+			rule: Rule{Name: "Busy", MetricID: "Requests", MetricType: s.Counter, Rate: true, Op: opGT, Threshold: 5},
+		},
+		{
+			name:    "no operator",
+			line:    "HeapAlloc 10",
+			wantErr: true,
+		},
+		{
+			name:    "bad duration",
+			line:    "HeapAlloc > 10 for ever",
+			wantErr: true,
+		},
+		{
+			name:    "unbalanced rate",
+			line:    "rate(PollCount > 1",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := ParseRule(test.line)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected error for %q", test.line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rule.MetricID != test.rule.MetricID || rule.MetricType != test.rule.MetricType || rule.Rate != test.rule.Rate ||
+				rule.Op != test.rule.Op || rule.Threshold != test.rule.Threshold ||
+				rule.For != test.rule.For {
+				t.Errorf("got %+v, want %+v", *rule, test.rule)
+			}
+			if test.rule.Name != "" && rule.Name != test.rule.Name {
+				t.Errorf("got name %q, want %q", rule.Name, test.rule.Name)
+			}
+		})
+	}
+}
+
+func TestEngineStates(t *testing.T) {
+	rule, _ := ParseRule("HeapAlloc > 10 for 2s")
+	eng := NewEngine([]*Rule{rule})
+	start := time.Now()
+
+	eng.Eval(start, Values{heapAlloc: 20})
+	if a := eng.Active(); len(a) != 1 || a[0].State != StatePending {
+		t.Fatalf("expected pending alert, got %+v", a)
+	}
+	changed := eng.Eval(start.Add(2*time.Second), Values{heapAlloc: 20})
+	if len(changed) != 1 || changed[0].State != StateFiring {
+		t.Fatalf("expected firing transition, got %+v", changed)
+	}
+	changed = eng.Eval(start.Add(3*time.Second), Values{heapAlloc: 5})
+	if len(changed) != 1 || changed[0].State != StateResolved {
+		t.Fatalf("expected resolved transition, got %+v", changed)
+	}
+	if a := eng.Active(); len(a) != 0 {
+		t.Fatalf("expected no active alerts, got %+v", a)
+	}
+}
+
+func TestEngineTypedValues(t *testing.T) {
+	typed, _ := ParseRule("counter:Requests > 5")
+	untyped, _ := ParseRule("Requests > 5")
+	eng := NewEngine([]*Rule{typed, untyped})
+	// gauge и counter с одним id не затирают друг друга
+	eng.Eval(time.Now(), Values{
+		{Type: s.Gauge, ID: "Requests"}:   1,
+		{Type: s.Counter, ID: "Requests"}: 10,
+	})
+	a := eng.Active()
+	if len(a) != 1 || a[0].Rule != typed.Name || a[0].Value != 10 {
+		t.Fatalf("expected only the counter rule to fire, got %+v", a)
+	}
+}
@@ -3,10 +3,16 @@ package compress
 import (
 	"bytes"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+
+	log "metrics/internal/logger"
+	"metrics/internal/selfstat"
+
+	"go.uber.org/zap"
 )
 
 type compressWriter struct {
@@ -57,7 +63,11 @@ func newCompressReader(r io.ReadCloser) (*compressReader, error) {
 }
 
 func (c compressReader) Read(p []byte) (n int, err error) {
-	return c.zr.Read(p)
+	n, err = c.zr.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		selfstat.Inc(selfstat.Name("gzip", "failures"))
+	}
+	return n, err
 }
 
 func (c *compressReader) Close() error {
@@ -74,8 +84,10 @@ func GzipMiddleware(h http.Handler) http.Handler {
 		ow := w
 
 		// проверяем, что клиент умеет получать от сервера сжатые данные в формате gzip
+		// потоковые ответы (SSE) не сжимаем: каждый Write сжимается отдельно
 		acceptEncoding := r.Header.Get("Accept-Encoding")
-		supportsGzip := strings.Contains(acceptEncoding, "gzip")
+		streaming := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+		supportsGzip := strings.Contains(acceptEncoding, "gzip") && !streaming
 		if supportsGzip {
 			// оборачиваем оригинальный http.ResponseWriter новым с поддержкой сжатия
 			cw := newCompressWriter(w)
@@ -91,6 +103,8 @@ func GzipMiddleware(h http.Handler) http.Handler {
 			// оборачиваем тело запроса в io.Reader с поддержкой декомпрессии
 			cr, err := newCompressReader(r.Body)
 			if err != nil {
+				log.Ctx(r.Context()).Debug("GzipMiddleware(): bad gzip body", zap.Error(err))
+				selfstat.Inc(selfstat.Name("gzip", "failures"))
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
@@ -3,15 +3,17 @@ package config
 import (
 	ctx "context"
 	"fmt"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"metrics/internal/agent"
+	"metrics/internal/alert"
+	"metrics/internal/derive"
 	log "metrics/internal/logger"
 	"metrics/internal/server"
+	"metrics/internal/tracing"
 
 	"go.uber.org/zap"
 )
@@ -24,12 +26,32 @@ type config struct {
 	Address         string `env:"ADDRESS"`
 	Key             string `env:"KEY"`
 	FileStoragePath string
+	Storage         string `env:"STORAGE"`
 	DBAddress       string `env:"DATABASE_DSN"`
 	StoreInterval   int    `env:"STORE_INTERVAL" envDefault:"-1"`
+	StoreBackups    int    `env:"STORE_BACKUPS" envDefault:"-1"`
 	PollInterval    int    `env:"POLL_INTERVAL" envDefault:"-1"`
 	ReportInterval  int    `env:"REPORT_INTERVAL" envDefault:"-1"`
 	Restore         bool   `env:"RESTORE" envDefault:"true"`
 	RateLimit       int    `env:"RATE_LIMIT"`
+	DebugAddress    string `env:"DEBUG_ADDRESS"`
+	ReportSelf      bool   `env:"REPORT_SELF"`
+	TraceEndpoint   string `env:"TRACE_ENDPOINT"`
+	RulesFile       string `env:"RULES_FILE"`
+	RulesInterval   int    `env:"RULES_INTERVAL" envDefault:"-1"`
+	Webhooks        string `env:"ALERT_WEBHOOKS"`
+	WebhookDedup    int    `env:"WEBHOOK_DEDUP" envDefault:"-1"`
+	DerivedFile     string `env:"DERIVED_FILE"`
+	HistoryDays     int    `env:"HISTORY_DAYS" envDefault:"-1"`
+	DBBufferPath    string `env:"DB_BUFFER_PATH"`
+	LogLevel        string `env:"LOG_LEVEL"`
+	LogFormat       string `env:"LOG_FORMAT"`
+	LogFile         string `env:"LOG_FILE"`
+	LogMaxSize      int    `env:"LOG_MAX_SIZE" envDefault:"-1"`
+	LogMaxBackups   int    `env:"LOG_MAX_BACKUPS" envDefault:"-1"`
+	LogMaxAge       int    `env:"LOG_MAX_AGE" envDefault:"-1"`
+	LogSampling     bool   `env:"LOG_SAMPLING"`
+	AdminToken      string `env:"ADMIN_TOKEN"`
 }
 
 type Option func(*config) error
@@ -42,25 +64,52 @@ const (
 )
 
 func Configure(cx ctx.Context, appType AppType, opts ...Option) (Executable, error) {
-	err := log.InitLog()
-	if err != nil {
-		return nil, fmt.Errorf("init log: %w", err)
-	}
 	cfg := &config{}
 	for _, op := range opts {
 		if err := op(cfg); err != nil {
+			// логгер еще не настроен: ошибка не должна потеряться
+			_ = log.InitLog(log.Config{})
 			return nil, err
 		}
 	}
+	err := log.InitLog(log.Config{
+		Level:      cfg.LogLevel,
+		Format:     cfg.LogFormat,
+		File:       cfg.LogFile,
+		MaxSize:    cfg.LogMaxSize,
+		MaxBackups: cfg.LogMaxBackups,
+		MaxAge:     cfg.LogMaxAge,
+		Sampling:   cfg.LogSampling,
+	})
+	if err != nil {
+		_ = log.InitLog(log.Config{})
+		return nil, fmt.Errorf("init log: %w", err)
+	}
+	service := "metrics-server"
+	if appType == Agent {
+		service = "metrics-agent"
+	}
+	if err := tracing.Init(cx, cfg.TraceEndpoint, service); err != nil {
+		return nil, fmt.Errorf("init tracing: %w", err)
+	}
 	switch appType {
 	case Server:
 		log.Info("MetricManager configuration",
 			zap.String("addr", cfg.Address),
 			zap.Int("store interval", cfg.StoreInterval),
+			zap.Int("store backups", cfg.StoreBackups),
 			zap.Bool("restore", cfg.Restore),
 			zap.String("file store", cfg.FileStoragePath),
 			zap.String("database", cfg.DBAddress),
-			zap.String("decrypt key", cfg.Key))
+			zap.String("storage", cfg.Storage),
+			zap.Int("history days", cfg.HistoryDays),
+			zap.String("db buffer", cfg.DBBufferPath),
+			zap.String("decrypt key", cfg.Key),
+			zap.String("rules", cfg.RulesFile),
+			zap.Int("rules interval", cfg.RulesInterval),
+			zap.Int("webhook dedup", cfg.WebhookDedup),
+			zap.String("derived", cfg.DerivedFile),
+			zap.String("trace endpoint", cfg.TraceEndpoint))
 		return NewManager(cx, cfg)
 	default:
 		log.Info("SelfMonitor configuration",
@@ -68,19 +117,51 @@ func Configure(cx ctx.Context, appType AppType, opts ...Option) (Executable, err
 			zap.Int("poll interval", cfg.PollInterval),
 			zap.Int("report interval", cfg.ReportInterval),
 			zap.String("encrypt key", cfg.Key),
-			zap.Int("rate limit", cfg.RateLimit))
+			zap.Int("rate limit", cfg.RateLimit),
+			zap.String("debug addr", cfg.DebugAddress),
+			zap.Bool("report self", cfg.ReportSelf),
+			zap.String("trace endpoint", cfg.TraceEndpoint))
 		return NewMonitor(cfg)
 	}
 }
 
 func NewManager(cx ctx.Context, cfg *config) (*server.MetricManager, error) {
 	var err error
-	manager := &server.MetricManager{Server: http.Server{}}
+	manager := server.NewMetricManager()
 	manager.Addr = cfg.Address
 	manager.Handler = getRoutes(cx, manager, cfg)
 	manager.Storage, err = setStorage(cx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, check := range server.StorageChecks(manager.Storage) {
+		manager.AddReadinessCheck(check.Name, check.Fn)
+	}
+	if cfg.RulesFile != "" {
+		rules, err := alert.LoadRules(cfg.RulesFile)
+		if err != nil {
+			return nil, fmt.Errorf("rules configure error: %w", err)
+		}
+		manager.Alerts = alert.NewEngine(rules)
+		manager.RulesInterval = time.Duration(cfg.RulesInterval) * time.Second
+	}
+	if cfg.DerivedFile != "" {
+		defs, err := derive.LoadDefs(cfg.DerivedFile)
+		if err != nil {
+			return nil, fmt.Errorf("derived configure error: %w", err)
+		}
+		manager.Derived = derive.NewSet(defs)
+	}
+	if cfg.Webhooks != "" {
+		hooks, err := alert.ParseWebhooks(cfg.Webhooks)
+		if err != nil {
+			return nil, fmt.Errorf("webhooks configure error: %w", err)
+		}
+		manager.Notifier = alert.NewNotifier(hooks,
+			time.Duration(cfg.WebhookDedup)*time.Second)
+	}
 
-	return manager, err
+	return manager, nil
 }
 
 func NewMonitor(cfg *config) (*agent.SelfMonitor, error) {
@@ -89,6 +170,8 @@ func NewMonitor(cfg *config) (*agent.SelfMonitor, error) {
 	monitor.PollInterval = time.Duration(cfg.PollInterval) * time.Second
 	monitor.ReportInterval = time.Duration(cfg.ReportInterval) * time.Second
 	monitor.Key = cfg.Key
+	monitor.DebugAddress = cfg.DebugAddress
+	monitor.ReportSelf = cfg.ReportSelf
 	if cfg.RateLimit <= 0 {
 		monitor.Rate = 1
 	} else {
@@ -2,27 +2,90 @@ package config
 
 import (
 	ctx "context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	c "metrics/internal/compress"
 	log "metrics/internal/logger"
 	sec "metrics/internal/security"
 	"metrics/internal/server"
+	"metrics/internal/tracing"
 
 	"github.com/go-chi/chi/v5"
 )
 
+var ErrStorageSpec = errors.New("invalid storage spec")
+
+// NewStorage открывает хранилище по спецификации вида <scheme>:<path>, как
+// -storage у сервера. Файловое хранилище восстанавливается и пишет WAL
+// синхронно, буфер на время недоступности БД не включается.
+func NewStorage(cx ctx.Context, spec string) (server.Storage, error) {
+	if spec == "" {
+		return nil, fmt.Errorf("%w: empty", ErrStorageSpec)
+	}
+	return openStorage(cx, &config{Storage: spec, Restore: true})
+}
+
 func setStorage(cx ctx.Context, cfg *config) (server.Storage, error) {
+	st, err := openStorage(cx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if db, ok := st.(*server.DataBase); ok {
+		db.EnableBuffer(cx, cfg.DBBufferPath)
+	}
+	return st, nil
+}
+
+// openStorage выбирает хранилище по -storage=<scheme>:<path>, а если он
+// не задан - по адресу БД или пути к файлу.
+func openStorage(cx ctx.Context, cfg *config) (server.Storage, error) {
+	if cfg.Storage != "" {
+		scheme, path, _ := strings.Cut(cfg.Storage, ":")
+		switch scheme {
+		case "mem":
+			return server.NewMemStore(), nil
+		case "file":
+			cfg.FileStoragePath = path
+			cfg.DBAddress = ""
+		case "kv":
+			if path == "" {
+				return nil, fmt.Errorf("%w: %q", ErrStorageSpec, cfg.Storage)
+			}
+			kv, err := server.NewKVStore(path)
+			if err != nil {
+				return nil, fmt.Errorf("kv configure error: %w", err)
+			}
+			return kv, nil
+		case "postgres", "postgresql", "sqlite":
+			cfg.DBAddress = cfg.Storage
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrStorageSpec, cfg.Storage)
+		}
+	}
 	switch {
+	case strings.HasPrefix(cfg.DBAddress, "sqlite:"):
+		db, err := server.NewSQLite(cx, cfg.DBAddress)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite configure error: %w", err)
+		}
+		return db, nil
 	case cfg.DBAddress != "":
 		db, err := server.NewDB(cx, cfg.DBAddress)
 		if err != nil {
 			return nil, fmt.Errorf("db configure error: %w", err)
 		}
+		if cfg.HistoryDays > 0 {
+			if err := db.EnableHistory(cx, cfg.HistoryDays); err != nil {
+				return nil, fmt.Errorf("db configure error: %w", err)
+			}
+		}
 		return db, nil
 	case cfg.FileStoragePath != "":
 		fs := server.NewFileStore(cfg.FileStoragePath, cfg.StoreInterval)
+		fs.Backups = cfg.StoreBackups
 		if cfg.Restore {
 			fs.RestoreFromFile(cx)
 		}
@@ -41,17 +104,36 @@ func getRoutes(cx ctx.Context, m *server.MetricManager, cfg *config) *chi.Mux {
 			next.ServeHTTP(w, r.WithContext(customCtx))
 		})
 	}
+	traced := tracing.HandlerFunc
+	signed := func(name string, fn http.HandlerFunc) http.HandlerFunc {
+		return traced("HashMiddleware", sec.HashMiddleware(cfg.Key, traced(name, fn)))
+	}
 	router := chi.NewRouter()
-	router.Use(log.WithHandlerLog)
-	router.Use(c.GzipMiddleware)
+	router.Use(tracing.Server)
+	router.Use(tracing.Middleware("WithHandlerLog", log.WithHandlerLog))
+	router.Use(server.WithStats)
+	router.Use(tracing.Middleware("GzipMiddleware", c.GzipMiddleware))
 	router.Use(ctxMiddleware)
-	router.Get("/", m.GetAllHandler)
-	router.Get("/ping", m.PingHandler)
-	router.Post("/value/", sec.HashMiddleware(cfg.Key, m.GetJSON))
-	router.Get("/value/{type}/{id}", m.GetHandler)
-	router.Post("/update/", sec.HashMiddleware(cfg.Key, m.UpdateJSON))
-	router.Post("/update/{type}/{id}/{value}", m.UpdateHandler)
-	router.Post("/updates/", sec.HashMiddleware(cfg.Key, m.BatchHandler))
+	router.Get("/", traced("GetAllHandler", m.GetAllHandler))
+	router.Get("/stream", traced("StreamHandler", m.StreamHandler))
+	router.Get("/alerts", traced("AlertsHandler", m.AlertsHandler))
+	router.Get("/history/{type}/{id}", traced("HistoryHandler", m.HistoryHandler))
+	router.Handle("/static/*", server.StaticHandler())
+	router.Get("/ping", traced("PingHandler", m.PingHandler))
+	router.Get("/healthz", m.HealthzHandler)
+	router.Get("/readyz", m.ReadyzHandler)
+	router.Get("/metrics", traced("SelfMetricsHandler", m.SelfMetricsHandler))
+	router.Post("/value/", signed("GetJSON", m.GetJSON))
+	router.Get("/value/{type}/{id}", traced("GetHandler", m.GetHandler))
+	router.Post("/update/", signed("UpdateJSON", m.UpdateJSON))
+	router.Post("/update/{type}/{id}/{value}", traced("UpdateHandler", m.UpdateHandler))
+	router.Post("/updates/", signed("BatchHandler", m.BatchHandler))
+	router.Post("/v1/metrics", traced("OTLPHandler", m.OTLPHandler))
+	if cfg.Key != "" || cfg.AdminToken != "" {
+		admin := sec.AdminMiddleware(cfg.Key, cfg.AdminToken, log.LevelHandler)
+		router.Get("/admin/loglevel", traced("LevelHandler", admin))
+		router.Put("/admin/loglevel", traced("LevelHandler", admin))
+	}
 
 	return router
 }
@@ -0,0 +1,419 @@
+package derive
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	s "metrics/internal/service"
+)
+
+const (
+	defaultRateWindow = time.Minute
+	binaryOps         = "+-*/"
+)
+
+var ErrInvalidDef = errors.New("invalid derived metric")
+
+type exprKind uint8
+
+const (
+	kindRate exprKind = iota
+	kindAggregate
+	kindBinary
+)
+
+type sample struct {
+	at  time.Time
+	val float64
+}
+
+// Def описывает производную метрику. Результат всегда gauge.
+type Def struct {
+	Name    string
+	Expr    string
+	kind    exprKind
+	args    []ref
+	op      string
+	window  time.Duration
+	mtx     sync.Mutex
+	samples []sample
+}
+
+// Key различает gauge и counter с одинаковым id.
+type Key struct {
+	Type string
+	ID   string
+}
+
+// Values - снимок значений метрик.
+type Values map[Key]float64
+
+// ref - аргумент выражения: id, шаблон или число. Тип можно указать
+// явно (counter:PollCount), без него подходят метрики обоих типов, а при
+// совпадении id значение gauge берется раньше counter.
+type ref struct {
+	mtype string
+	id    string
+}
+
+func parseRef(arg string) ref {
+	for _, mtype := range []string{s.Gauge, s.Counter} {
+		if id, ok := strings.CutPrefix(arg, mtype+":"); ok {
+			return ref{mtype: mtype, id: id}
+		}
+	}
+	return ref{id: arg}
+}
+
+func (r ref) match(k Key) bool {
+	if r.mtype != "" && r.mtype != k.Type {
+		return false
+	}
+	ok, _ := path.Match(r.id, k.ID)
+	return ok
+}
+
+func (r ref) keys() []Key {
+	if r.mtype != "" {
+		return []Key{{Type: r.mtype, ID: r.id}}
+	}
+	if _, err := strconv.ParseFloat(r.id, 64); err == nil {
+		return nil
+	}
+	return []Key{{Type: s.Gauge, ID: r.id}, {Type: s.Counter, ID: r.id}}
+}
+
+func (r ref) lookup(vals Values) (float64, bool) {
+	for _, k := range r.keys() {
+		if v, ok := vals[k]; ok {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+func ParseDef(line string) (*Def, error) {
+	name, expr, ok := strings.Cut(line, "=")
+	name, expr = strings.TrimSpace(name), strings.TrimSpace(expr)
+	if !ok || name == "" || expr == "" || strings.ContainsAny(name, " ()*") {
+		return nil, fmt.Errorf("%w %q: expected <name> = <expr>", ErrInvalidDef, line)
+	}
+	def := &Def{Name: name, Expr: expr}
+	if fn, rest, ok := strings.Cut(expr, "("); ok {
+		inner, ok := strings.CutSuffix(rest, ")")
+		if !ok {
+			return nil, fmt.Errorf("%w %q: unbalanced parentheses", ErrInvalidDef, line)
+		}
+		return def, def.parseCall(strings.TrimSpace(fn), inner)
+	}
+	a, op, b, ok := splitBinary(expr)
+	if !ok {
+		return nil, fmt.Errorf("%w %q: expected <a> <+|-|*|/> <b>", ErrInvalidDef, line)
+	}
+	def.kind = kindBinary
+	def.args = []ref{parseRef(a), parseRef(b)}
+	def.op = op
+	return def, nil
+}
+
+// splitBinary делит выражение по оператору; пробелы вокруг него не
+// обязательны. Если место оператора неоднозначно (id с дефисом без
+// пробелов), выражение отклоняется.
+func splitBinary(expr string) (a, op, b string, ok bool) {
+	if f := strings.Fields(expr); len(f) == 3 && len(f[1]) == 1 && strings.Contains(binaryOps, f[1]) {
+		return f[0], f[1], f[2], true
+	}
+	found := 0
+	for i := 1; i < len(expr); i++ {
+		if !strings.ContainsRune(binaryOps, rune(expr[i])) {
+			continue
+		}
+		left, right := strings.TrimSpace(expr[:i]), strings.TrimSpace(expr[i+1:])
+		if left == "" || right == "" || strings.ContainsAny(left+right, " \t") ||
+			strings.ContainsRune(binaryOps, rune(left[len(left)-1])) || isExponent(expr[:i], expr[i]) {
+			continue
+		}
+		a, op, b = left, expr[i:i+1], right
+		found++
+	}
+	return a, op, b, found == 1
+}
+
+// isExponent сообщает, что знак относится к экспоненте числа (1e-3).
+func isExponent(left string, sign byte) bool {
+	if sign != '+' && sign != '-' {
+		return false
+	}
+	num := left[strings.LastIndexAny(left, binaryOps+" ")+1:]
+	if !strings.HasSuffix(num, "e") && !strings.HasSuffix(num, "E") {
+		return false
+	}
+	_, err := strconv.ParseFloat(num+"0", 64)
+	return err == nil
+}
+
+func (d *Def) parseCall(fn, inner string) error {
+	args := strings.Split(inner, ",")
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+	if args[0] == "" {
+		return fmt.Errorf("%w %q: empty argument", ErrInvalidDef, d.Expr)
+	}
+	switch fn {
+	case "rate":
+		d.kind = kindRate
+		d.window = defaultRateWindow
+		if len(args) > 2 {
+			return fmt.Errorf("%w %q: rate(id[, window])", ErrInvalidDef, d.Expr)
+		}
+		if len(args) == 2 {
+			w, err := time.ParseDuration(args[1])
+			if err != nil || w <= 0 {
+				return fmt.Errorf("%w %q: bad window", ErrInvalidDef, d.Expr)
+			}
+			d.window = w
+		}
+		d.args = []ref{parseRef(args[0])}
+	case "sum", "avg", "min", "max":
+		if len(args) != 1 {
+			return fmt.Errorf("%w %q: %s(pattern)", ErrInvalidDef, d.Expr, fn)
+		}
+		arg := parseRef(args[0])
+		if _, err := path.Match(arg.id, ""); err != nil {
+			return fmt.Errorf("%w %q: bad pattern", ErrInvalidDef, d.Expr)
+		}
+		d.kind = kindAggregate
+		d.op = fn
+		d.args = []ref{arg}
+	default:
+		return fmt.Errorf("%w %q: unknown function %s", ErrInvalidDef, d.Expr, fn)
+	}
+	return nil
+}
+
+// DependsOn сообщает, влияет ли изменение метрики k на результат.
+func (d *Def) DependsOn(k Key) bool {
+	for _, arg := range d.args {
+		if arg.match(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// Deps возвращает метрики, нужные для вычисления; all означает, что
+// выражение зависит от шаблона и нужен полный список.
+func (d *Def) Deps() (keys []Key, all bool) {
+	if d.kind == kindAggregate {
+		return nil, true
+	}
+	for _, arg := range d.args {
+		keys = append(keys, arg.keys()...)
+	}
+	return keys, false
+}
+
+func (d *Def) Eval(now time.Time, vals Values) (float64, bool) {
+	return d.eval(now, vals, nil)
+}
+
+func (d *Def) eval(now time.Time, vals Values, skip func(Key) bool) (float64, bool) {
+	switch d.kind {
+	case kindRate:
+		return d.rate(now, vals)
+	case kindAggregate:
+		return d.aggregate(vals, skip)
+	default:
+		return d.binary(vals)
+	}
+}
+
+func (d *Def) rate(now time.Time, vals Values) (float64, bool) {
+	val, ok := d.args[0].lookup(vals)
+	if !ok {
+		return 0, false
+	}
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if n := len(d.samples); n > 0 && val < d.samples[n-1].val { // сброс счетчика
+		d.samples = d.samples[:0]
+	}
+	d.samples = append(d.samples, sample{at: now, val: val})
+	cut := 0
+	for cut < len(d.samples)-1 && now.Sub(d.samples[cut].at) > d.window {
+		cut++
+	}
+	d.samples = d.samples[cut:]
+	first, last := d.samples[0], d.samples[len(d.samples)-1]
+	dt := last.at.Sub(first.at).Seconds()
+	if dt <= 0 {
+		return 0, false
+	}
+	return (last.val - first.val) / dt, true
+}
+
+func (d *Def) aggregate(vals Values, skip func(Key) bool) (float64, bool) {
+	var res float64
+	n := 0
+	for k, v := range vals {
+		if (skip != nil && skip(k)) || !d.args[0].match(k) {
+			continue
+		}
+		switch {
+		case n == 0:
+			res = v
+		case d.op == "min" && v < res, d.op == "max" && v > res:
+			res = v
+		case d.op == "sum", d.op == "avg":
+			res += v
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, false
+	}
+	if d.op == "avg" {
+		res /= float64(n)
+	}
+	return res, true
+}
+
+func (d *Def) binary(vals Values) (float64, bool) {
+	a, okA := operand(d.args[0], vals)
+	b, okB := operand(d.args[1], vals)
+	if !okA || !okB {
+		return 0, false
+	}
+	switch d.op {
+	case "+":
+		return a + b, true
+	case "-":
+		return a - b, true
+	case "*":
+		return a * b, true
+	default:
+		if b == 0 {
+			return 0, false
+		}
+		return a / b, true
+	}
+}
+
+func operand(arg ref, vals Values) (float64, bool) {
+	if num, err := strconv.ParseFloat(arg.id, 64); err == nil && arg.mtype == "" {
+		return num, true
+	}
+	return arg.lookup(vals)
+}
+
+type Set struct {
+	defs  []*Def
+	names map[string]struct{}
+}
+
+func NewSet(defs []*Def) *Set {
+	names := make(map[string]struct{}, len(defs))
+	for _, d := range defs {
+		names[d.Name] = struct{}{}
+	}
+	return &Set{defs: defs, names: names}
+}
+
+// derived сообщает, что k - результат одного из определений.
+func (set *Set) derived(k Key) bool {
+	_, ok := set.names[k.ID]
+	return ok && k.Type == s.Gauge
+}
+
+// affected возвращает определения, зависящие хотя бы от одной из changed.
+// Производные метрики пересчет не вызывают.
+func (set *Set) affected(changed []Key) []*Def {
+	var defs []*Def
+	for _, d := range set.defs {
+		for _, k := range changed {
+			if !set.derived(k) && d.DependsOn(k) {
+				defs = append(defs, d)
+				break
+			}
+		}
+	}
+	return defs
+}
+
+func (set *Set) Affects(changed []Key) bool {
+	return len(set.affected(changed)) > 0
+}
+
+// Deps объединяет зависимости затронутых определений, чтобы не читать
+// все хранилище ради пары значений.
+func (set *Set) Deps(changed []Key) (keys []Key, all bool) {
+	seen := make(map[Key]struct{})
+	for _, d := range set.affected(changed) {
+		dk, dall := d.Deps()
+		all = all || dall
+		for _, k := range dk {
+			if _, dup := seen[k]; !dup {
+				seen[k] = struct{}{}
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys, all
+}
+
+// Eval пересчитывает определения, зависящие хотя бы от одной из changed.
+// Производные метрики не участвуют в агрегатах.
+func (set *Set) Eval(now time.Time, changed []Key, vals Values) map[string]float64 {
+	res := make(map[string]float64)
+	for _, d := range set.affected(changed) {
+		if v, ok := d.eval(now, vals, set.derived); ok {
+			res[d.Name] = v
+		}
+	}
+	return res
+}
+
+// ReadDefs читает по одному определению на строку, пустые строки и
+// комментарии (#) пропускаются.
+func ReadDefs(r io.Reader) ([]*Def, error) {
+	var defs []*Def
+	names := make(map[string]struct{})
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		def, err := ParseDef(line)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := names[def.Name]; dup {
+			return nil, fmt.Errorf("%w: duplicate name %q", ErrInvalidDef, def.Name)
+		}
+		names[def.Name] = struct{}{}
+		defs = append(defs, def)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read derived: %w", err)
+	}
+	return defs, nil
+}
+
+func LoadDefs(filePath string) ([]*Def, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("load derived: %w", err)
+	}
+	defer f.Close()
+	return ReadDefs(f)
+}
@@ -0,0 +1,100 @@
+package derive
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	s "metrics/internal/service"
+)
+
+func gauge(id string) Key   { return Key{Type: s.Gauge, ID: id} }
+func counter(id string) Key { return Key{Type: s.Counter, ID: id} }
+
+func TestSetEval(t *testing.T) {
+	defs, err := ReadDefs(strings.NewReader(`
+# производные метрики
+HeapRatio = HeapAlloc/HeapSys
+CPUTotal = sum(CPUutilization*)
+CPUMax = max(CPUutilization*)
+PollRate = rate(PollCount, 10s)
+`))
+	if err != nil {
+		t.Fatalf("read defs: %v", err)
+	}
+	set := NewSet(defs)
+	start := time.Now()
+	vals := Values{
+		gauge("HeapAlloc"):       5,
+		gauge("HeapSys"):         20,
+		gauge("CPUutilization1"): 10,
+		gauge("CPUutilization2"): 30,
+		gauge("CPUTotal"):        1000, // старое производное значение не должно учитываться
+		counter("PollCount"):     10,
+	}
+	changed := []Key{gauge("HeapAlloc"), gauge("CPUutilization1"), counter("PollCount")}
+	res := set.Eval(start, changed, vals)
+	if res["HeapRatio"] != 0.25 || res["CPUTotal"] != 40 || res["CPUMax"] != 30 {
+		t.Errorf("unexpected results: %v", res)
+	}
+	if _, ok := res["PollRate"]; ok {
+		t.Errorf("rate needs two samples, got %v", res["PollRate"])
+	}
+
+	vals[counter("PollCount")] = 30
+	res = set.Eval(start.Add(5*time.Second), []Key{counter("PollCount")}, vals)
+	if res["PollRate"] != 4 || len(res) != 1 {
+		t.Errorf("unexpected rate results: %v", res)
+	}
+	if set.Affects([]Key{gauge("CPUTotal"), gauge("Alloc")}) {
+		t.Error("derived and unrelated ids must not trigger recalculation")
+	}
+	// counter с именем производной метрики - обычный вход
+	if !set.Affects([]Key{counter("CPUutilizationX")}) {
+		t.Error("counter must trigger aggregate recalculation")
+	}
+
+	keys, all := set.Deps([]Key{gauge("HeapSys")})
+	if all || len(keys) != 4 {
+		t.Errorf("HeapRatio deps = %v, %v", keys, all)
+	}
+	if _, all := set.Deps([]Key{gauge("CPUutilization2")}); !all {
+		t.Error("aggregate needs the full list")
+	}
+}
+
+func TestTypedOperands(t *testing.T) {
+	defs, err := ReadDefs(strings.NewReader(`
+Mixed = gauge:Requests - counter:Requests
+Scaled = Requests*1e-3
+`))
+	if err != nil {
+		t.Fatalf("read defs: %v", err)
+	}
+	vals := Values{gauge("Requests"): 7, counter("Requests"): 2}
+	res := NewSet(defs).Eval(time.Now(), []Key{counter("Requests")}, vals)
+	if res["Mixed"] != 5 || res["Scaled"] != 0.007 {
+		t.Errorf("unexpected results: %v", res)
+	}
+}
+
+func TestParseDefErrors(t *testing.T) {
+	for _, line := range []string{
+		"NoExpr =",
+		"Bad = HeapAlloc % HeapSys",
+		"Bad = Heap-Alloc/HeapSys",
+		"Bad = a - b - c",
+		"Bad = rate(PollCount, never)",
+		"Bad = median(CPU*)",
+		"Bad = sum(CPU*",
+	} {
+		if _, err := ParseDef(line); err == nil {
+			t.Errorf("expected error for %q", line)
+		}
+	}
+	for _, line := range []string{"Ok = x*-1", "Ok = Heap-Alloc / HeapSys", "Ok = 2+x"} {
+		if _, err := ParseDef(line); err != nil {
+			t.Errorf("%q: %v", line, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var ErrLevelTTL = errors.New("ttl must be positive")
+
+// Level возвращает уровень логгера, который можно менять на ходу.
+func Level() zap.AtomicLevel {
+	return level
+}
+
+// levelRevert - отложенный возврат уровня после временного изменения.
+type levelRevert struct {
+	mtx   sync.Mutex
+	timer *time.Timer
+	to    zapcore.Level
+	at    time.Time
+}
+
+var revert levelRevert
+
+type levelState struct {
+	Level    string     `json:"level"`
+	RevertTo string     `json:"revert_to,omitempty"`
+	RevertAt *time.Time `json:"revert_at,omitempty"`
+}
+
+type levelRequest struct {
+	Level string `json:"level"`
+	TTL   string `json:"ttl,omitempty"`
+}
+
+// SetLevel меняет уровень; при ttl > 0 через ttl вернется уровень, бывший
+// до первого из временных изменений. Новый вызов отменяет прежний возврат.
+func SetLevel(lvl zapcore.Level, ttl time.Duration) {
+	revert.mtx.Lock()
+	defer revert.mtx.Unlock()
+	if revert.timer != nil {
+		revert.timer.Stop()
+	} else {
+		revert.to = level.Level()
+	}
+	revert.timer = nil
+	level.SetLevel(lvl)
+	if ttl <= 0 {
+		return
+	}
+	revert.at = time.Now().Add(ttl)
+	// логгер берется сейчас: таймер не должен читать глобальный base
+	reverted := base
+	var timer *time.Timer
+	timer = time.AfterFunc(ttl, func() {
+		revert.mtx.Lock()
+		defer revert.mtx.Unlock()
+		if revert.timer != timer { // уже отменен новым SetLevel
+			return
+		}
+		revert.timer = nil
+		level.SetLevel(revert.to)
+		reverted.Info("log level reverted", zap.Stringer("level", revert.to))
+	})
+	revert.timer = timer
+}
+
+func currentLevel() levelState {
+	revert.mtx.Lock()
+	defer revert.mtx.Unlock()
+	state := levelState{Level: level.String()}
+	if revert.timer != nil {
+		at := revert.at
+		state.RevertTo, state.RevertAt = revert.to.String(), &at
+	}
+	return state
+}
+
+// LevelHandler - GET отдает текущий уровень, PUT с телом
+// {"level":"debug","ttl":"15m"} меняет его (ttl необязателен).
+func LevelHandler(rw http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPut {
+		lvl, ttl, err := parseLevelRequest(req)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		SetLevel(lvl, ttl)
+		Ctx(req.Context()).Info("log level changed",
+			zap.Stringer("level", lvl), zap.Duration("ttl", ttl))
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(currentLevel())
+}
+
+func parseLevelRequest(req *http.Request) (zapcore.Level, time.Duration, error) {
+	var body levelRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return 0, 0, fmt.Errorf("decode level request: %w", err)
+	}
+	lvl, err := zapcore.ParseLevel(body.Level)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parse level: %w", err)
+	}
+	var ttl time.Duration
+	if body.TTL != "" {
+		if ttl, err = time.ParseDuration(body.TTL); err != nil {
+			return 0, 0, fmt.Errorf("parse ttl: %w", err)
+		}
+		if ttl <= 0 {
+			return 0, 0, ErrLevelTTL
+		}
+	}
+	return lvl, ttl, nil
+}
@@ -0,0 +1,45 @@
+package logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLevelHandlerRevert(t *testing.T) {
+	level.SetLevel(zapcore.InfoLevel)
+	defer level.SetLevel(zapcore.InfoLevel)
+
+	put := func(body string) int {
+		rec := httptest.NewRecorder()
+		LevelHandler(rec, httptest.NewRequest(http.MethodPut, "/admin/loglevel", strings.NewReader(body)))
+		return rec.Code
+	}
+	if code := put(`{"level":"loud"}`); code != http.StatusBadRequest {
+		t.Fatalf("invalid level accepted: %d", code)
+	}
+	if code := put(`{"level":"debug","ttl":"50ms"}`); code != http.StatusOK {
+		t.Fatalf("put: %d", code)
+	}
+	// повторное изменение не должно сбивать уровень, к которому вернемся
+	if code := put(`{"level":"warn","ttl":"50ms"}`); code != http.StatusOK {
+		t.Fatalf("put: %d", code)
+	}
+	rec := httptest.NewRecorder()
+	LevelHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/loglevel", nil))
+	if !strings.Contains(rec.Body.String(), `"level":"warn","revert_to":"info"`) {
+		t.Fatalf("unexpected state: %s", rec.Body.String())
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for level.Level() != zapcore.InfoLevel && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if level.Level() != zapcore.InfoLevel {
+		t.Errorf("level not reverted: %s", level.Level())
+	}
+}
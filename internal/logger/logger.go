@@ -1,14 +1,29 @@
 package logger
 
 import (
+	ctx "context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+const (
+	samplingInitial    = 100
+	samplingThereafter = 100
+	maxRequestID       = 128
+)
+
+var ErrLogFormat = errors.New("unknown log format")
+
 func Debug(msg string, fields ...zapcore.Field) { // обертки:
 	logger.Debug(msg, fields...)
 }
@@ -29,43 +44,134 @@ func Fatal(msg string, fields ...zapcore.Field) {
 	logger.Fatal(msg, fields...)
 }
 
-var logger *zap.Logger = zap.NewNop()
-
-func InitLog() error {
-	atomicLevel := zap.NewAtomicLevel()
-	atomicLevel.SetLevel(zapcore.DebugLevel)
-
-	config := zap.Config{
-		Level:       atomicLevel,
-		Development: false,
-		Encoding:    "console",
-		EncoderConfig: zapcore.EncoderConfig{
-			TimeKey:        "timestamp",
-			LevelKey:       "level",
-			NameKey:        "logger",
-			CallerKey:      "caller",
-			MessageKey:     "msg",
-			StacktraceKey:  "stacktrace",
-			LineEnding:     zapcore.DefaultLineEnding,
-			EncodeLevel:    zapcore.LowercaseLevelEncoder,
-			EncodeTime:     zapcore.ISO8601TimeEncoder,
-			EncodeDuration: zapcore.StringDurationEncoder,
-			EncodeCaller:   zapcore.ShortCallerEncoder,
-		},
-		OutputPaths:      []string{"stdout"},
-		ErrorOutputPaths: []string{"stderr"},
+const RequestIDHeader = "X-Request-ID"
+
+// Config - настройки логгера. Пустой File означает вывод в stdout.
+type Config struct {
+	Level      string
+	Format     string // json | console
+	File       string
+	MaxSize    int // МБ до ротации
+	MaxBackups int
+	MaxAge     int // дни
+	Sampling   bool
+}
+
+var (
+	base   *zap.Logger = zap.NewNop() // для логгеров запросов
+	logger *zap.Logger = zap.NewNop() // для оберток, пропускает их кадр
+	level              = zap.NewAtomicLevel()
+)
+
+func InitLog(cfg Config) error {
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return fmt.Errorf("log level %q: %w", cfg.Level, err)
+		}
 	}
 
-	var err error
-	logger, err = config.Build()
-	if err != nil {
-		return fmt.Errorf("build config for logger error: %w", err)
+	encoderConfig := zapcore.EncoderConfig{
+		TimeKey:        "timestamp",
+		LevelKey:       "level",
+		NameKey:        "logger",
+		CallerKey:      "caller",
+		MessageKey:     "msg",
+		StacktraceKey:  "stacktrace",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     zapcore.ISO8601TimeEncoder,
+		EncodeDuration: zapcore.StringDurationEncoder,
+		EncodeCaller:   zapcore.ShortCallerEncoder,
+	}
+	var encoder zapcore.Encoder
+	switch cfg.Format {
+	case "json":
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	case "", "console":
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	default:
+		return fmt.Errorf("%w: %q", ErrLogFormat, cfg.Format)
 	}
 
-	logger.Debug("Logger configured and running with Debug level")
+	out := zapcore.Lock(os.Stdout)
+	if cfg.File != "" {
+		out = zapcore.AddSync(&lumberjack.Logger{
+			Filename:   cfg.File,
+			MaxSize:    cfg.MaxSize,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAge,
+		})
+	}
+
+	core := zapcore.NewCore(encoder, out, level)
+	if cfg.Sampling {
+		// в секунду пишем первые 100 одинаковых сообщений, дальше каждое сотое
+		core = zapcore.NewSamplerWithOptions(core, time.Second, samplingInitial, samplingThereafter)
+	}
+	base = zap.New(core,
+		zap.AddCaller(),
+		zap.AddStacktrace(zapcore.ErrorLevel),
+		zap.ErrorOutput(zapcore.Lock(os.Stderr)))
+	logger = base.WithOptions(zap.AddCallerSkip(1))
+
+	logger.Debug("Logger configured and running",
+		zap.String("level", level.String()),
+		zap.String("format", encoderName(cfg.Format)),
+		zap.String("file", cfg.File))
 	return nil
 }
 
+func encoderName(format string) string {
+	if format == "" {
+		return "console"
+	}
+	return format
+}
+
+type ctxKey struct{}
+
+// WithLogger сохраняет логгер в контексте.
+func WithLogger(cx ctx.Context, l *zap.Logger) ctx.Context {
+	return ctx.WithValue(cx, ctxKey{}, l)
+}
+
+// Ctx возвращает логгер запроса (с request_id), либо общий логгер.
+func Ctx(cx ctx.Context) *zap.Logger {
+	if l, ok := cx.Value(ctxKey{}).(*zap.Logger); ok {
+		return l
+	}
+	return base
+}
+
+// RequestID возвращает идентификатор запроса из контекста.
+func RequestID(cx ctx.Context) string {
+	id, _ := cx.Value(requestIDKey{}).(string)
+	return id
+}
+
+type requestIDKey struct{}
+
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID отсекает чужие идентификаторы, которые могут испортить лог.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
 type loggingResponse struct {
 	http.ResponseWriter
 	status int
@@ -75,7 +181,14 @@ type loggingResponse struct {
 func (r *loggingResponse) Write(b []byte) (int, error) {
 	size, err := r.ResponseWriter.Write(b)
 	r.size += size
-	return size, fmt.Errorf("response writing error: %w", err)
+	if err != nil {
+		return size, fmt.Errorf("response writing error: %w", err)
+	}
+	return size, nil
+}
+
+func (r *loggingResponse) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
 }
 
 func (r *loggingResponse) WriteHeader(statusCode int) {
@@ -87,6 +200,15 @@ func WithHandlerLog(h http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		reqLog := base.With(zap.String("request_id", id))
+		cx := ctx.WithValue(r.Context(), requestIDKey{}, id)
+		r = r.WithContext(WithLogger(cx, reqLog))
+
 		logResp := loggingResponse{
 			ResponseWriter: w,
 			status:         0,
@@ -97,7 +219,7 @@ func WithHandlerLog(h http.Handler) http.Handler {
 
 		duration := time.Since(start)
 
-		logger.Info("Request/Response logging:",
+		reqLog.Info("Request/Response logging:",
 			zap.String("uri", r.RequestURI),
 			zap.String("method", r.Method),
 			zap.Int("status", logResp.status),
@@ -0,0 +1,48 @@
+package logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestWithHandlerLogRequestID(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	base = zap.New(core)
+	defer func() { base = zap.NewNop() }()
+
+	var seen string
+	h := WithHandlerLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+		Ctx(r.Context()).Info("inside handler")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if seen != "abc-123" || rec.Header().Get(RequestIDHeader) != "abc-123" {
+		t.Fatalf("request id not propagated: ctx=%q header=%q", seen, rec.Header().Get(RequestIDHeader))
+	}
+	entries := logs.All()
+	if len(entries) != 2 {
+		t.Fatalf("want 2 log lines, got %d", len(entries))
+	}
+	for _, e := range entries {
+		if e.ContextMap()["request_id"] != "abc-123" {
+			t.Errorf("%q: missing request_id: %v", e.Message, e.ContextMap())
+		}
+	}
+
+	// без заголовка (или с мусором в нем) идентификатор генерируется
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "bad\nid")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if id := rec.Header().Get(RequestIDHeader); len(id) != 32 || id != seen {
+		t.Errorf("unexpected generated id %q (ctx %q)", id, seen)
+	}
+}
@@ -0,0 +1,331 @@
+// Package otlp переводит OTLP-метрики в модель хранилища: Sum - в counter
+// (кумулятивные значения переводятся в дельты по каждому ряду), Gauge - в
+// gauge, Histogram - в семейство рядов _bucket/_count/_sum.
+package otlp
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	s "metrics/internal/service"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+var ErrUnsupported = errors.New("unsupported metric type")
+
+// series - состояние ряда-счетчика между запросами. total копится во
+// float, а в хранилище уходит целая часть: дробные дельты не теряются.
+type series struct {
+	start   uint64
+	last    float64
+	total   float64
+	emitted int64
+}
+
+// Converter хранит состояние кумулятивных рядов; безопасен для
+// конкурентного использования.
+type Converter struct {
+	mtx     sync.Mutex
+	idle    chan struct{} // закрывается и заменяется при освобождении рядов
+	series  map[string]series
+	busy    map[string]struct{}
+	started uint64
+}
+
+func NewConverter() *Converter {
+	return &Converter{
+		idle:    make(chan struct{}),
+		series:  make(map[string]series),
+		busy:    make(map[string]struct{}),
+		started: uint64(time.Now().UnixNano()),
+	}
+}
+
+// Batch - результат конвертации запроса. Состояние рядов меняется только
+// после Commit, чтобы при ошибке записи повтор запроса дал те же дельты.
+// До Commit или Discard ряды батча заняты: пересекающийся запрос ждет,
+// иначе оба посчитали бы дельту от одной базы.
+type Batch struct {
+	Metrics  []*s.Metrics
+	Rejected int64
+	Errors   []string
+	conv     *Converter
+	pending  map[string]series
+	done     bool
+}
+
+func (b *Batch) Commit() {
+	b.release(true)
+}
+
+// Discard освобождает ряды без изменения состояния; после Commit ничего
+// не делает.
+func (b *Batch) Discard() {
+	b.release(false)
+}
+
+func (b *Batch) release(commit bool) {
+	b.conv.mtx.Lock()
+	defer b.conv.mtx.Unlock()
+	if b.done {
+		return
+	}
+	b.done = true
+	for id, st := range b.pending {
+		if commit {
+			b.conv.series[id] = st
+		}
+		delete(b.conv.busy, id)
+	}
+	close(b.conv.idle)
+	b.conv.idle = make(chan struct{})
+}
+
+// Convert переводит запрос в метрики. Если ряды запроса заняты другим
+// батчем, конвертация повторяется после его завершения; ожидание
+// прерывается отменой cx, тогда возвращается ее ошибка.
+func (c *Converter) Convert(cx ctx.Context, req *colmetricspb.ExportMetricsServiceRequest) (*Batch, error) {
+	for {
+		c.mtx.Lock()
+		b := &Batch{conv: c, pending: make(map[string]series)}
+		for _, rm := range req.GetResourceMetrics() {
+			resource := attrMap(rm.GetResource().GetAttributes())
+			for _, sm := range rm.GetScopeMetrics() {
+				for _, m := range sm.GetMetrics() {
+					if err := c.convertMetric(b, m, resource); err != nil {
+						b.Errors = append(b.Errors, err.Error())
+					}
+				}
+			}
+		}
+		if !c.overlaps(b) {
+			for id := range b.pending {
+				c.busy[id] = struct{}{}
+			}
+			c.mtx.Unlock()
+			return b, nil
+		}
+		idle := c.idle
+		c.mtx.Unlock()
+		select {
+		case <-idle:
+		case <-cx.Done():
+			return nil, fmt.Errorf("otlp convert: %w", cx.Err())
+		}
+	}
+}
+
+func (c *Converter) overlaps(b *Batch) bool {
+	for id := range b.pending {
+		if _, busy := c.busy[id]; busy {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Converter) convertMetric(b *Batch, m *metricspb.Metric, resource map[string]string) error {
+	name := m.GetName()
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		for _, dp := range data.Gauge.GetDataPoints() {
+			val := numberValue(dp)
+			b.add(&s.Metrics{ID: SeriesID(name, resource, dp.GetAttributes()), MType: s.Gauge, Value: &val})
+		}
+	case *metricspb.Metric_Sum:
+		cumulative := data.Sum.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		for _, dp := range data.Sum.GetDataPoints() {
+			id := SeriesID(name, resource, dp.GetAttributes())
+			val := numberValue(dp)
+			if !data.Sum.GetIsMonotonic() {
+				// немонотонная сумма может уменьшаться - это gauge
+				b.add(&s.Metrics{ID: id, MType: s.Gauge, Value: &val})
+				continue
+			}
+			c.addCounter(b, id, dp.GetStartTimeUnixNano(), val, cumulative)
+		}
+	case *metricspb.Metric_Histogram:
+		cumulative := data.Histogram.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		for _, dp := range data.Histogram.GetDataPoints() {
+			c.addHistogram(b, name, resource, dp, cumulative)
+		}
+	default:
+		points := dataPoints(m)
+		b.Rejected += int64(points)
+		return fmt.Errorf("%w: %s (%d points)", ErrUnsupported, name, points)
+	}
+	return nil
+}
+
+func (c *Converter) addHistogram(b *Batch, name string, resource map[string]string,
+	dp *metricspb.HistogramDataPoint, cumulative bool,
+) {
+	start := dp.GetStartTimeUnixNano()
+	bounds := dp.GetExplicitBounds()
+	var count uint64
+	for i, n := range dp.GetBucketCounts() {
+		count += n // как в Prometheus: бакет le считает все значения <= границы
+		le := "+Inf"
+		if i < len(bounds) {
+			le = strconv.FormatFloat(bounds[i], 'g', -1, 64)
+		}
+		id := SeriesID(name+"_bucket", resource, dp.GetAttributes(), &commonpb.KeyValue{
+			Key:   "le",
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: le}},
+		})
+		c.addCounter(b, id, start, float64(count), cumulative)
+	}
+	c.addCounter(b, SeriesID(name+"_count", resource, dp.GetAttributes()), start,
+		float64(dp.GetCount()), cumulative)
+	// _sum - gauge с накопленной суммой: сумма наблюдений бывает дробной
+	// и отрицательной, целочисленный counter ее бы исказил
+	sumID := SeriesID(name+"_sum", resource, dp.GetAttributes())
+	if st, ok := c.apply(b, sumID, start, dp.GetSum(), cumulative); ok {
+		total := st.total
+		b.add(&s.Metrics{ID: sumID, MType: s.Gauge, Value: &total})
+	}
+}
+
+// addCounter добавляет дельту ряда-счетчика. Первая кумулятивная точка
+// ряда только запоминается как база, если ряд начался до запуска сервера:
+// иначе после рестарта весь накопленный итог прибавился бы повторно.
+func (c *Converter) addCounter(b *Batch, id string, start uint64, val float64, cumulative bool) {
+	st, ok := c.apply(b, id, start, val, cumulative)
+	if !ok {
+		return
+	}
+	delta := int64(math.Round(st.total)) - st.emitted
+	st.emitted += delta
+	b.pending[id] = st
+	b.add(&s.Metrics{ID: id, MType: s.Counter, Delta: &delta})
+}
+
+// apply вызывается под c.mtx
+func (c *Converter) apply(b *Batch, id string, start uint64, val float64, cumulative bool) (series, bool) {
+	st, seen := b.pending[id]
+	if !seen {
+		st, seen = c.series[id]
+	}
+	var inc float64
+	switch {
+	case !cumulative:
+		inc = val
+	case !seen:
+		st.start, st.last = start, val
+		b.pending[id] = st
+		if start == 0 || start < c.started {
+			return st, false
+		}
+		inc = val
+	case start == st.start && start != 0 && val < st.last:
+		// запоздавшая точка того же ряда: база не откатывается назад
+		return st, false
+	case start != st.start || val < st.last: // сброс ряда
+		inc = val
+	default:
+		inc = val - st.last
+	}
+	if cumulative {
+		st.start, st.last = start, val
+	}
+	st.total += inc
+	b.pending[id] = st
+	return st, true
+}
+
+func (b *Batch) add(met *s.Metrics) {
+	b.Metrics = append(b.Metrics, met)
+}
+
+func numberValue(dp *metricspb.NumberDataPoint) float64 {
+	if v, ok := dp.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+		return float64(v.AsInt)
+	}
+	return dp.GetAsDouble()
+}
+
+func dataPoints(m *metricspb.Metric) int {
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_ExponentialHistogram:
+		return len(data.ExponentialHistogram.GetDataPoints())
+	case *metricspb.Metric_Summary:
+		return len(data.Summary.GetDataPoints())
+	}
+	return 0
+}
+
+func attrMap(attrs []*commonpb.KeyValue) map[string]string {
+	res := make(map[string]string, len(attrs))
+	for _, kv := range attrs {
+		res[kv.GetKey()] = anyString(kv.GetValue())
+	}
+	return res
+}
+
+func anyString(v *commonpb.AnyValue) string {
+	switch val := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return val.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(val.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(val.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(val.DoubleValue, 'g', -1, 64)
+	case *commonpb.AnyValue_BytesValue:
+		return fmt.Sprintf("%x", val.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		parts := make([]string, 0, len(val.ArrayValue.GetValues()))
+		for _, item := range val.ArrayValue.GetValues() {
+			parts = append(parts, anyString(item))
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	}
+	return ""
+}
+
+// SeriesID кодирует метки в ID в стиле Prometheus: name{k="v",...} с
+// отсортированными ключами. Атрибуты точки перекрывают атрибуты ресурса.
+func SeriesID(name string, resource map[string]string, attrs []*commonpb.KeyValue, extra ...*commonpb.KeyValue) string {
+	labels := make(map[string]string, len(resource)+len(attrs)+len(extra))
+	for k, v := range resource {
+		labels[k] = v
+	}
+	for _, kv := range attrs {
+		labels[kv.GetKey()] = anyString(kv.GetValue())
+	}
+	for _, kv := range extra {
+		labels[kv.GetKey()] = anyString(kv.GetValue())
+	}
+	if len(labels) == 0 {
+		return name
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString(name)
+	sb.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(k)
+		sb.WriteString("=")
+		sb.WriteString(strconv.Quote(labels[k]))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
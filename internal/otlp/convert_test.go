@@ -0,0 +1,194 @@
+package otlp
+
+import (
+	ctx "context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+func exportOf(metrics ...*metricspb.Metric) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{{
+				Key:   "service.name",
+				Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "svc"}},
+			}}},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+		}},
+	}
+}
+
+func cumulativeSum(name string, start uint64, val int64) *metricspb.Metric {
+	return &metricspb.Metric{Name: name, Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+		IsMonotonic:            true,
+		AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+		DataPoints: []*metricspb.NumberDataPoint{{
+			StartTimeUnixNano: start,
+			Value:             &metricspb.NumberDataPoint_AsInt{AsInt: val},
+		}},
+	}}}
+}
+
+func convert(t *testing.T, c *Converter, req *colmetricspb.ExportMetricsServiceRequest) *Batch {
+	t.Helper()
+	b, err := c.Convert(ctx.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func deltas(b *Batch) map[string]int64 {
+	res := make(map[string]int64)
+	for _, met := range b.Metrics {
+		if met.Delta != nil {
+			res[met.ID] = *met.Delta
+		}
+	}
+	return res
+}
+
+func TestConvertCumulativeSum(t *testing.T) {
+	c := NewConverter()
+	id := `requests{service.name="svc"}`
+	before := c.started - 1
+
+	// ряд начался до запуска: первая точка - только база
+	b := convert(t, c, exportOf(cumulativeSum("requests", before, 10)))
+	b.Commit()
+	if len(b.Metrics) != 0 {
+		t.Fatalf("baseline point emitted: %v", deltas(b))
+	}
+
+	// без Commit повтор запроса дает ту же дельту
+	for i := 0; i < 2; i++ {
+		b = convert(t, c, exportOf(cumulativeSum("requests", before, 15)))
+		if got := deltas(b)[id]; got != 5 {
+			t.Fatalf("attempt %d: want delta 5, got %v", i, deltas(b))
+		}
+		if i == 0 {
+			b.Discard()
+		}
+	}
+	b.Commit()
+	b.Discard() // после Commit ничего не меняет
+
+	// запоздавшая точка того же ряда пропускается
+	b = convert(t, c, exportOf(cumulativeSum("requests", before, 12)))
+	b.Commit()
+	if len(b.Metrics) != 0 {
+		t.Fatalf("stale point emitted: %v", deltas(b))
+	}
+
+	// сброс ряда: новое время старта
+	b = convert(t, c, exportOf(cumulativeSum("requests", c.started+1, 3)))
+	b.Commit()
+	if got := deltas(b)[id]; got != 3 {
+		t.Fatalf("after reset want delta 3, got %v", deltas(b))
+	}
+}
+
+func TestConvertHistogram(t *testing.T) {
+	c := NewConverter()
+	b := convert(t, c, exportOf(&metricspb.Metric{
+		Name: "latency",
+		Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+			DataPoints: []*metricspb.HistogramDataPoint{{
+				Count:          6,
+				Sum:            func() *float64 { v := 12.5; return &v }(),
+				ExplicitBounds: []float64{1, 5},
+				BucketCounts:   []uint64{1, 2, 3},
+			}},
+		}},
+	}, &metricspb.Metric{
+		Name: "summary",
+		Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{
+			DataPoints: []*metricspb.SummaryDataPoint{{}},
+		}},
+	}))
+	b.Commit()
+
+	want := map[string]int64{
+		`latency_bucket{le="1",service.name="svc"}`:    1,
+		`latency_bucket{le="5",service.name="svc"}`:    3,
+		`latency_bucket{le="+Inf",service.name="svc"}`: 6,
+		`latency_count{service.name="svc"}`:            6,
+	}
+	got := deltas(b)
+	for id, delta := range want {
+		if got[id] != delta {
+			t.Errorf("%s: want %d, got %d", id, delta, got[id])
+		}
+	}
+	var sum *float64
+	for _, met := range b.Metrics {
+		if met.ID == `latency_sum{service.name="svc"}` {
+			sum = met.Value
+		}
+	}
+	if sum == nil || *sum != 12.5 {
+		t.Errorf("unexpected latency_sum: %v", sum)
+	}
+	if b.Rejected != 1 || len(b.Errors) != 1 {
+		t.Errorf("summary must be rejected: %d %v", b.Rejected, b.Errors)
+	}
+}
+
+func TestConvertOverlappingBatches(t *testing.T) {
+	c := NewConverter()
+	start := c.started + 1
+	id := `requests{service.name="svc"}`
+	var (
+		mtx   sync.Mutex
+		total int64
+	)
+	export := func(val int64) {
+		b, err := c.Convert(ctx.Background(), exportOf(cumulativeSum("requests", start, val)))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mtx.Lock()
+		total += deltas(b)[id]
+		mtx.Unlock()
+		b.Commit()
+	}
+
+	export(10)
+	// пересекающиеся запросы не считают дельту от одной базы
+	var wg sync.WaitGroup
+	for _, val := range []int64{15, 20} {
+		wg.Add(1)
+		go func(val int64) {
+			defer wg.Done()
+			export(val)
+		}(val)
+	}
+	wg.Wait()
+	export(25)
+	if total != 25 {
+		t.Fatalf("stored total %d, want 25", total)
+	}
+}
+
+func TestConvertWaitCanceled(t *testing.T) {
+	c := NewConverter()
+	req := exportOf(cumulativeSum("requests", c.started+1, 10))
+	b := convert(t, c, req)
+
+	cx, cancel := ctx.WithTimeout(ctx.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Convert(cx, req); !errors.Is(err, ctx.DeadlineExceeded) {
+		t.Fatalf("convert of busy series = %v, want deadline exceeded", err)
+	}
+	b.Discard()
+	convert(t, c, req).Commit()
+}
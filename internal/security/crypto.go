@@ -7,8 +7,12 @@ import (
 	"encoding/hex"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	log "metrics/internal/logger"
+	"metrics/internal/selfstat"
 
 	"go.uber.org/zap"
 )
@@ -41,27 +45,28 @@ func Hash(data *[]byte, key string) string {
 
 func HashMiddleware(key string, next http.HandlerFunc) http.HandlerFunc {
 	return func(rw http.ResponseWriter, req *http.Request) {
-		log.Debug("hash middleware...")
+		log.Ctx(req.Context()).Debug("hash middleware...")
 		ow := rw
 		sign := req.Header.Get("HashSHA256")
 		body, err := io.ReadAll(req.Body)
 		if err != nil {
-			log.Warn("HashMiddleware: body err:", zap.Error(err))
+			log.Ctx(req.Context()).Warn("HashMiddleware: body err:", zap.Error(err))
 			ow.WriteHeader(http.StatusBadRequest)
 			return
 		}
 		if key == "" || sign == "" || len(body) == 0 {
-			log.Info("without hash...")
+			log.Ctx(req.Context()).Info("without hash...")
 			req.Body = io.NopCloser(bytes.NewBuffer(body))
 			next.ServeHTTP(ow, req)
 			return
 		}
 		srcSign := Hash(&body, key)
 		if srcSign != sign {
-			log.Warn("HashMiddleware: sing error",
+			log.Ctx(req.Context()).Warn("HashMiddleware: sing error",
 				zap.String("src", srcSign),
 				zap.String("sign", sign),
 			)
+			selfstat.Inc(selfstat.Name("hash", "failures"))
 			rw.WriteHeader(http.StatusBadRequest)
 			return
 		}
@@ -70,3 +75,64 @@ func HashMiddleware(key string, next http.HandlerFunc) http.HandlerFunc {
 		next.ServeHTTP(ow, req)
 	}
 }
+
+const (
+	AdminTimestampHeader = "X-Admin-Timestamp"
+	adminSignatureWindow = 5 * time.Minute
+)
+
+var now = time.Now
+
+// AdminSignature - подпись админ-запроса ключом key. Подписываются время
+// (unix-секунды), метод, путь и тело, поэтому перехваченную подпись нельзя
+// использовать для другого запроса или позже adminSignatureWindow.
+func AdminSignature(key, timestamp, method, path string, body []byte) string {
+	data := make([]byte, 0, len(timestamp)+len(method)+len(path)+len(body)+3)
+	data = append(data, timestamp+"\n"+method+" "+path+"\n"...)
+	data = append(data, body...)
+	return Hash(&data, key)
+}
+
+// AdminMiddleware пускает запрос с токеном (Authorization: Bearer <token>)
+// или с подписью AdminSignature в HashSHA256 и временем подписи в
+// X-Admin-Timestamp. Внутри окна подпись можно повторить, поэтому при
+// доступе к запросам третьих лиц надежнее токен поверх TLS.
+func AdminMiddleware(key, token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewBuffer(body))
+		bearer, hasBearer := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		switch {
+		case token != "" && hasBearer && hmac.Equal([]byte(bearer), []byte(token)):
+		case key != "" && validAdminSignature(key, req, body):
+		default:
+			log.Ctx(req.Context()).Warn("AdminMiddleware: unauthorized",
+				zap.String("path", req.URL.Path))
+			selfstat.Inc(selfstat.Name("admin", "denied"))
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(rw, req)
+	}
+}
+
+func validAdminSignature(key string, req *http.Request, body []byte) bool {
+	sign := req.Header.Get("HashSHA256")
+	timestamp := req.Header.Get(AdminTimestampHeader)
+	if sign == "" || timestamp == "" {
+		return false
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now().Sub(time.Unix(sec, 0)); age > adminSignatureWindow || age < -adminSignatureWindow {
+		return false
+	}
+	expected := AdminSignature(key, timestamp, req.Method, req.URL.Path, body)
+	return hmac.Equal([]byte(sign), []byte(expected))
+}
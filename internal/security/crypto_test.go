@@ -0,0 +1,54 @@
+package security
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminMiddleware(t *testing.T) {
+	const key, token = "key", "s3cret"
+	handler := AdminMiddleware(key, token, func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	at := time.Unix(1_700_000_000, 0)
+	now = func() time.Time { return at }
+	defer func() { now = time.Now }()
+	ts := strconv.FormatInt(at.Unix(), 10)
+	body := `{"level":"debug"}`
+
+	signed := func(ts, method, path, body string) map[string]string {
+		return map[string]string{
+			"HashSHA256":         AdminSignature(key, ts, method, path, []byte(body)),
+			AdminTimestampHeader: ts,
+		}
+	}
+	stale := strconv.FormatInt(at.Add(-time.Hour).Unix(), 10)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"token", map[string]string{"Authorization": "Bearer " + token}, http.StatusOK},
+		{"bad token", map[string]string{"Authorization": "Bearer nope"}, http.StatusUnauthorized},
+		{"signature", signed(ts, http.MethodPut, "/admin/loglevel", body), http.StatusOK},
+		{"stale signature", signed(stale, http.MethodPut, "/admin/loglevel", body), http.StatusUnauthorized},
+		{"signature of other request", signed(ts, http.MethodGet, "/admin/loglevel", ""), http.StatusUnauthorized},
+		{"no timestamp", map[string]string{"HashSHA256": AdminSignature(key, "", http.MethodPut, "/admin/loglevel", []byte(body))}, http.StatusUnauthorized},
+		{"no credentials", nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPut, "/admin/loglevel", strings.NewReader(body))
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
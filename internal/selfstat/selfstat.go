@@ -0,0 +1,105 @@
+// Package selfstat собирает метрики о работе самого сервера. Счетчики
+// копятся в памяти и периодически сбрасываются в хранилище дельтами.
+package selfstat
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	s "metrics/internal/service"
+)
+
+// Prefix зарезервирован: клиентам запрещено писать метрики с таким ID.
+const Prefix = "__server."
+
+var registry = struct {
+	mtx      sync.RWMutex
+	counters map[string]*atomic.Int64
+	gauges   map[string]*atomic.Uint64
+}{
+	counters: make(map[string]*atomic.Int64),
+	gauges:   make(map[string]*atomic.Uint64),
+}
+
+// Name собирает ID метрики из частей: Name("http", "requests") -> "__server.http.requests".
+func Name(parts ...string) string {
+	return Prefix + strings.Join(parts, ".")
+}
+
+func IsReserved(id string) bool {
+	return strings.HasPrefix(id, Prefix)
+}
+
+func counter(name string) *atomic.Int64 {
+	registry.mtx.RLock()
+	c, ok := registry.counters[name]
+	registry.mtx.RUnlock()
+	if ok {
+		return c
+	}
+	registry.mtx.Lock()
+	defer registry.mtx.Unlock()
+	if c, ok = registry.counters[name]; !ok {
+		c = &atomic.Int64{}
+		registry.counters[name] = c
+	}
+	return c
+}
+
+func gauge(name string) *atomic.Uint64 {
+	registry.mtx.RLock()
+	g, ok := registry.gauges[name]
+	registry.mtx.RUnlock()
+	if ok {
+		return g
+	}
+	registry.mtx.Lock()
+	defer registry.mtx.Unlock()
+	if g, ok = registry.gauges[name]; !ok {
+		g = &atomic.Uint64{}
+		registry.gauges[name] = g
+	}
+	return g
+}
+
+func Add(name string, delta int64) {
+	counter(name).Add(delta)
+}
+
+func Inc(name string) {
+	Add(name, 1)
+}
+
+func Set(name string, val float64) {
+	gauge(name).Store(math.Float64bits(val))
+}
+
+// Collect забирает накопленные дельты счетчиков (обнуляя их) и текущие
+// значения gauge. Если запись не удалась, дельты возвращаются через Restore.
+func Collect() []*s.Metrics {
+	registry.mtx.RLock()
+	defer registry.mtx.RUnlock()
+	mets := make([]*s.Metrics, 0, len(registry.counters)+len(registry.gauges))
+	for name, c := range registry.counters {
+		if delta := c.Swap(0); delta != 0 {
+			mets = append(mets, &s.Metrics{ID: name, MType: s.Counter, Delta: &delta})
+		}
+	}
+	for name, g := range registry.gauges {
+		val := math.Float64frombits(g.Load())
+		mets = append(mets, &s.Metrics{ID: name, MType: s.Gauge, Value: &val})
+	}
+	sort.Slice(mets, func(i, j int) bool { return mets[i].ID < mets[j].ID })
+	return mets
+}
+
+func Restore(mets []*s.Metrics) {
+	for _, m := range mets {
+		if m.IsCounter() && m.Delta != nil {
+			Add(m.ID, *m.Delta)
+		}
+	}
+}
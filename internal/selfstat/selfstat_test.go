@@ -0,0 +1,23 @@
+package selfstat
+
+import "testing"
+
+func TestCollect(t *testing.T) {
+	Inc(Name("test", "hits"))
+	Add(Name("test", "hits"), 2)
+	Set(Name("test", "size"), 1.5)
+
+	mets := Collect()
+	if len(mets) != 2 || *mets[0].Delta != 3 || *mets[1].Value != 1.5 {
+		t.Fatalf("first collect = %v", mets)
+	}
+	// дельты обнуляются, gauge остается
+	Restore(mets)
+	mets = Collect()
+	if len(mets) != 2 || *mets[0].Delta != 3 {
+		t.Fatalf("collect after restore = %v", mets)
+	}
+	if mets = Collect(); len(mets) != 1 || !IsReserved(mets[0].ID) {
+		t.Fatalf("collect without updates = %v", mets)
+	}
+}
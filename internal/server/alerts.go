@@ -0,0 +1,104 @@
+package server
+
+import (
+	ctx "context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"metrics/internal/alert"
+	log "metrics/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+// defaultRulesInterval используется, если интервал не задан: NewTicker
+// паникует при неположительном периоде.
+const defaultRulesInterval = 10 * time.Second
+
+// notifyQueueSize - сколько тиков с изменениями может ждать доставки, пока
+// вычисление правил не начнет ждать уведомитель.
+const notifyQueueSize = 16
+
+func (mm *MetricManager) evalRules(cx ctx.Context) {
+	interval := mm.RulesInterval
+	if interval <= 0 {
+		log.Warn("evalRules(): bad interval, using default",
+			zap.Duration("interval", interval), zap.Duration("default", defaultRulesInterval))
+		interval = defaultRulesInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// уведомления отправляет один обработчик: переходы правила приходят
+	// получателю в том же порядке, в каком случились
+	var queue chan []alert.Alert
+	if mm.Notifier != nil {
+		queue = make(chan []alert.Alert, notifyQueueSize)
+		go mm.notify(cx, queue)
+	}
+	for {
+		select {
+		case now := <-ticker.C:
+			metrics, err := mm.List(cx)
+			if err != nil {
+				log.Warn("evalRules(): storage error", zap.Error(err))
+				continue
+			}
+			values := make(alert.Values, len(metrics))
+			for _, m := range metrics {
+				if v, ok := metricValue(m); ok {
+					values[alert.Key{Type: m.MType, ID: m.ID}] = v
+				}
+			}
+			changed := mm.Alerts.Eval(now, values)
+			for _, a := range changed {
+				logAlert(a)
+			}
+			if len(changed) > 0 && queue != nil {
+				select {
+				case queue <- changed:
+				case <-cx.Done():
+				}
+			}
+		case <-cx.Done():
+			log.Debug("evalRules is done...")
+			return
+		}
+	}
+}
+
+func (mm *MetricManager) notify(cx ctx.Context, queue <-chan []alert.Alert) {
+	for {
+		select {
+		case changed := <-queue:
+			// ошибки доставки Notifier логирует сам
+			_ = mm.Notifier.Notify(cx, changed)
+		case <-cx.Done():
+			return
+		}
+	}
+}
+
+func logAlert(a alert.Alert) {
+	log.Info("alert state changed",
+		zap.String("rule", a.Rule),
+		zap.String("expr", a.Expr),
+		zap.String("state", string(a.State)),
+		zap.Float64("value", a.Value))
+}
+
+func (mm *MetricManager) AlertsHandler(rw http.ResponseWriter, req *http.Request) {
+	alerts := []alert.Alert{}
+	if mm.Alerts != nil {
+		alerts = mm.Alerts.Active()
+	}
+	data, err := json.Marshal(alerts)
+	if err != nil {
+		log.Ctx(req.Context()).Warn("AlertsHandler(): marshal error", zap.Error(err))
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write(data)
+}
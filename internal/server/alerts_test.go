@@ -0,0 +1,69 @@
+package server
+
+import (
+	ctx "context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"metrics/internal/alert"
+)
+
+func TestEvalRulesBadInterval(t *testing.T) {
+	mm := NewMetricManager()
+	mm.RulesInterval = 0
+	cx, cancel := ctx.WithCancel(ctx.Background())
+	cancel()
+	// не должно паниковать на NewTicker(0)
+	mm.evalRules(cx)
+}
+
+func TestNotifyKeepsOrder(t *testing.T) {
+	var (
+		mtx    sync.Mutex
+		states []alert.State
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var p alert.Payload
+		_ = json.NewDecoder(req.Body).Decode(&p)
+		// первая доставка медленнее следующих
+		if len(p.Alerts) > 0 && p.Alerts[0].State == alert.StateFiring {
+			time.Sleep(50 * time.Millisecond)
+		}
+		mtx.Lock()
+		for _, a := range p.Alerts {
+			states = append(states, a.State)
+		}
+		mtx.Unlock()
+	}))
+	defer srv.Close()
+
+	mm := NewMetricManager()
+	mm.Notifier = alert.NewNotifier([]alert.Webhook{{URL: srv.URL}}, 0)
+	cx, cancel := ctx.WithCancel(ctx.Background())
+	defer cancel()
+	queue := make(chan []alert.Alert, notifyQueueSize)
+	go mm.notify(cx, queue)
+	queue <- []alert.Alert{{Rule: "cpu", State: alert.StateFiring}}
+	queue <- []alert.Alert{{Rule: "cpu", State: alert.StateResolved}}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mtx.Lock()
+		got := append([]alert.State(nil), states...)
+		mtx.Unlock()
+		if len(got) == 2 {
+			if got[0] != alert.StateFiring || got[1] != alert.StateResolved {
+				t.Fatalf("transitions out of order: %v", got)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("delivered %v, want 2 transitions", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "metrics/internal/logger"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+const (
+	breakerThreshold = 3
+	breakerCooldown  = 5 * time.Second
+)
+
+type breakerState uint8
+
+const (
+	stateClosed breakerState = iota
+	stateOpen
+	stateHalfOpen
+)
+
+func (st breakerState) String() string {
+	switch st {
+	case stateOpen:
+		return "open"
+	case stateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+var ErrCircuitOpen = fmt.Errorf("%w: circuit breaker is open", ErrConnDB)
+
+// circuitBreaker размыкается после breakerThreshold подряд ошибок
+// соединения и в течение cooldown сразу отказывает. Затем пропускает один
+// пробный запрос (half-open): успех замыкает цепь, ошибка снова размыкает.
+type circuitBreaker struct {
+	mtx       sync.Mutex
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	threshold int
+	cooldown  time.Duration
+	onChange  func(from, to breakerState)
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{
+		threshold: breakerThreshold,
+		cooldown:  breakerCooldown,
+	}
+}
+
+func (cb *circuitBreaker) State() breakerState {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+	return cb.state
+}
+
+func (cb *circuitBreaker) allow() error {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+	switch cb.state {
+	case stateClosed:
+		return nil
+	case stateOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return ErrCircuitOpen
+		}
+		cb.setState(stateHalfOpen)
+	}
+	if cb.probing {
+		return ErrCircuitOpen
+	}
+	cb.probing = true
+	return nil
+}
+
+// report учитывает результат операции; ошибки запроса (синтаксис,
+// отсутствие строк, нарушение ограничений) на состояние не влияют.
+func (cb *circuitBreaker) report(err error) {
+	connErr := isConnError(err)
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+	cb.probing = false
+	if errors.Is(err, ctx.Canceled) { // клиент ушел - о БД ничего не известно
+		return
+	}
+	if !connErr {
+		cb.failures = 0
+		if cb.state != stateClosed {
+			cb.setState(stateClosed)
+		}
+		return
+	}
+	cb.failures++
+	if cb.state == stateHalfOpen || cb.failures >= cb.threshold {
+		cb.openedAt = time.Now()
+		if cb.state != stateOpen {
+			cb.setState(stateOpen)
+		}
+	}
+}
+
+// setState вызывается под cb.mtx
+func (cb *circuitBreaker) setState(to breakerState) {
+	from := cb.state
+	cb.state = to
+	log.Info("db circuit breaker state changed",
+		zap.Stringer("from", from), zap.Stringer("to", to))
+	if cb.onChange != nil {
+		go cb.onChange(from, to)
+	}
+}
+
+// isConnError отделяет ошибки соединения с БД от ошибок самого запроса.
+func isConnError(err error) bool {
+	if err == nil || errors.Is(err, ctx.Canceled) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// 08 - connection exception, 57P0x - остановка сервера,
+		// 53300 - too many connections
+		return strings.HasPrefix(pgErr.Code, "08") ||
+			strings.HasPrefix(pgErr.Code, "57P0") ||
+			pgErr.Code == "53300"
+	}
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.As(err, &connectErr) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, ctx.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, ErrConnDB) ||
+		pgconn.Timeout(err) ||
+		pgconn.SafeToRetry(err)
+}
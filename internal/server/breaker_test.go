@@ -0,0 +1,52 @@
+package server
+
+import (
+	ctx "context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := newCircuitBreaker()
+	cb.cooldown = 10 * time.Millisecond
+
+	// ошибки запроса цепь не размыкают
+	for i := 0; i < breakerThreshold+1; i++ {
+		if err := cb.allow(); err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+		cb.report(&pgconn.PgError{Code: "23505"})
+	}
+	if st := cb.State(); st != stateClosed {
+		t.Fatalf("state after query errors = %s", st)
+	}
+
+	for i := 0; i < breakerThreshold; i++ {
+		_ = cb.allow()
+		cb.report(io.EOF)
+	}
+	if err := cb.allow(); !errors.Is(err, ErrCircuitOpen) || !errors.Is(err, ErrConnDB) {
+		t.Fatalf("allow while open = %v", err)
+	}
+
+	time.Sleep(2 * cb.cooldown)
+	if err := cb.allow(); err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+	if err := cb.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second probe allowed: %v", err)
+	}
+	cb.report(ctx.Canceled) // отмена клиентом не меняет состояние
+	if st := cb.State(); st != stateHalfOpen {
+		t.Fatalf("state after canceled probe = %s", st)
+	}
+	_ = cb.allow()
+	cb.report(nil)
+	if st := cb.State(); st != stateClosed {
+		t.Fatalf("state after successful probe = %s", st)
+	}
+}
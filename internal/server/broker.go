@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "metrics/internal/logger"
+	"metrics/internal/selfstat"
+	s "metrics/internal/service"
+
+	"go.uber.org/zap"
+)
+
+const subscriberBuffer = 64
+
+type streamEvent struct {
+	Delta *int64    `json:"delta,omitempty"`
+	Value *float64  `json:"value,omitempty"`
+	ID    string    `json:"id"`
+	MType string    `json:"type"`
+	Time  time.Time `json:"time"`
+}
+
+type subscriber struct {
+	ch      chan []byte
+	prefix  string
+	mtype   string
+	dropped atomic.Uint64
+}
+
+func (sub *subscriber) match(met *s.Metrics) bool {
+	if sub.mtype != "" && sub.mtype != met.MType {
+		return false
+	}
+	return strings.HasPrefix(met.ID, sub.prefix)
+}
+
+// broker раздает принятые обновления подписчикам /stream. Отправка
+// неблокирующая: если буфер подписчика полон, событие отбрасывается.
+// Общее число отброшенных событий видно в self-метрике stream.dropped.
+type broker struct {
+	mtx  sync.RWMutex
+	subs map[*subscriber]struct{}
+}
+
+func newBroker() *broker {
+	return &broker{subs: make(map[*subscriber]struct{})}
+}
+
+func (b *broker) subscribe(prefix, mtype string) *subscriber {
+	sub := &subscriber{
+		ch:     make(chan []byte, subscriberBuffer),
+		prefix: prefix,
+		mtype:  mtype,
+	}
+	b.mtx.Lock()
+	b.subs[sub] = struct{}{}
+	b.mtx.Unlock()
+	return sub
+}
+
+func (b *broker) unsubscribe(sub *subscriber) {
+	b.mtx.Lock()
+	delete(b.subs, sub)
+	b.mtx.Unlock()
+	if n := sub.dropped.Load(); n > 0 {
+		log.Info("stream subscriber dropped events", zap.Uint64("dropped", n))
+	}
+}
+
+func (b *broker) publish(mets ...*s.Metrics) {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	if len(b.subs) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, met := range mets {
+		var data []byte
+		for sub := range b.subs {
+			if !sub.match(met) {
+				continue
+			}
+			if data == nil {
+				data, _ = json.Marshal(streamEvent{
+					Delta: met.Delta,
+					Value: met.Value,
+					ID:    met.ID,
+					MType: met.MType,
+					Time:  now,
+				})
+			}
+			select {
+			case sub.ch <- data:
+			default:
+				sub.dropped.Add(1)
+				selfstat.Inc(selfstat.Name("stream", "dropped"))
+			}
+		}
+	}
+}
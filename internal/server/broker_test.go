@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bufio"
+	ctx "context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"metrics/internal/selfstat"
+	s "metrics/internal/service"
+)
+
+func TestBrokerPublish(t *testing.T) {
+	b := newBroker()
+	sub := b.subscribe("Poll", s.Counter)
+	defer b.unsubscribe(sub)
+
+	delta, val := int64(1), 1.0
+	b.publish(&s.Metrics{ID: "Alloc", MType: s.Gauge, Value: &val},
+		&s.Metrics{ID: "PollCount", MType: s.Gauge, Value: &val})
+	for i := 0; i < subscriberBuffer+3; i++ {
+		b.publish(&s.Metrics{ID: "PollCount", MType: s.Counter, Delta: &delta})
+	}
+	if len(sub.ch) != subscriberBuffer || sub.dropped.Load() != 3 {
+		t.Fatalf("queued %d, dropped %d", len(sub.ch), sub.dropped.Load())
+	}
+	var dropped int64
+	for _, m := range selfstat.Collect() {
+		if m.ID == selfstat.Name("stream", "dropped") {
+			dropped = *m.Delta
+		}
+	}
+	if dropped != 3 {
+		t.Fatalf("stream.dropped = %d, want 3", dropped)
+	}
+}
+
+func TestStreamHandler(t *testing.T) {
+	mm := NewMetricManager()
+	mm.Storage = NewMemStore()
+	srv := httptest.NewServer(http.HandlerFunc(mm.StreamHandler))
+	defer srv.Close()
+
+	cx, cancel := ctx.WithCancel(ctx.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(cx, http.MethodGet, srv.URL+"?type=counter", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+
+	// заголовки отправлены после подписки, события не теряются
+	val := 1.0
+	for _, delta := range []int64{2, 3} {
+		d := delta
+		_, _ = mm.Put(cx, &s.Metrics{ID: "g", MType: s.Gauge, Value: &val})
+		if _, err := mm.Put(cx, &s.Metrics{ID: "c", MType: s.Counter, Delta: &d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var events []string
+	sc := bufio.NewScanner(resp.Body)
+	for len(events) < 2 && sc.Scan() {
+		if data, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+			events = append(events, data)
+		}
+	}
+	// события несут итог счетчика, а не дельту запроса
+	if len(events) != 2 || !strings.Contains(events[0], `"delta":2`) || !strings.Contains(events[1], `"delta":5`) {
+		t.Fatalf("events = %v", events)
+	}
+
+	resp, err = http.Get(srv.URL + "?type=histogram")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad type status %d", resp.StatusCode)
+	}
+}
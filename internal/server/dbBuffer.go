@@ -0,0 +1,480 @@
+package server
+
+import (
+	ctx "context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "metrics/internal/logger"
+	s "metrics/internal/service"
+
+	"go.uber.org/zap"
+)
+
+const (
+	flushTimeout = 30 * time.Second
+	sealSuffix   = ".flush"
+)
+
+type bufferStore interface {
+	Put(ctx.Context, *s.Metrics) (*s.Metrics, error)
+	Get(ctx.Context, *s.Metrics) (*s.Metrics, error)
+	List(ctx.Context) ([]*s.Metrics, error)
+	reset(ctx.Context) error
+	Close()
+}
+
+// applyFunc применяет mets к БД, фиксируя token в той же транзакции.
+// seals - прежние попытки с неизвестным исходом: если какая-то из них
+// применена, применяется только остаток mets (см. unapplied).
+type applyFunc func(cx ctx.Context, mets []*s.Metrics, token string, seals []flushSeal) error
+
+// forgetFunc удаляет из БД token'ы печатей, которые больше не понадобятся.
+type forgetFunc func(cx ctx.Context, tokens []string) error
+
+// writeBuffer принимает записи, пока БД недоступна. Слияние значений то же,
+// что у MemStorage: дельты счетчиков складываются, gauge перезаписывается,
+// поэтому при сбросе в БД через PutBatch итог совпадает с прямой записью.
+//
+// Сброс идемпотентен: каждая попытка запечатывается token'ом, который БД
+// фиксирует в транзакции сброса, а печати с неизвестным исходом хранятся
+// рядом с файловым буфером (path.flush). Если коммит прошел, а буфер не
+// очищен (обрыв, ошибка или падение процесса), следующий сброс применит
+// только то, что в БД еще не попало.
+type writeBuffer struct {
+	mtx       sync.RWMutex
+	store     bufferStore
+	pending   atomic.Bool
+	applied   atomic.Bool // содержимое уже в БД, но буфер не очищен
+	seals     []flushSeal
+	sealPath  string
+	stale     []string         // token'ы сброшенных печатей, еще не удаленные из БД
+	totals    map[string]int64 // последние известные итоги счетчиков в БД
+	totalsMtx sync.Mutex
+	flushCh   chan struct{}
+}
+
+func newWriteBuffer(store bufferStore) *writeBuffer {
+	return &writeBuffer{
+		store:   store,
+		totals:  make(map[string]int64),
+		flushCh: make(chan struct{}, 1),
+	}
+}
+
+// EnableBuffer включает деградированный режим: при ошибке соединения
+// записи уходят в буфер в памяти, а если задан path - в FileStorage с
+// синхронным WAL, чтобы буфер пережил перезапуск.
+func (db *DataBase) EnableBuffer(cx ctx.Context, path string) {
+	buf := openWriteBuffer(cx, path)
+	db.buffer = buf
+	db.breaker.onChange = func(_, to breakerState) {
+		if to == stateClosed {
+			buf.signal()
+		}
+	}
+}
+
+func openWriteBuffer(cx ctx.Context, path string) *writeBuffer {
+	var store bufferStore = NewMemStore()
+	if path != "" {
+		fs := NewFileStore(path, 0)
+		fs.RestoreFromFile(cx)
+		store = fs
+	}
+	buf := newWriteBuffer(store)
+	if path != "" {
+		buf.sealPath = path + sealSuffix
+		seals, err := loadSeals(buf.sealPath)
+		if err != nil {
+			log.Warn("db buffer seals are lost, counters may be flushed twice", zap.Error(err))
+		}
+		buf.seals = seals
+	}
+	if mets, _ := store.List(cx); len(mets) > 0 {
+		log.Info("db buffer restored", zap.Int("metrics", len(mets)))
+		buf.pending.Store(true)
+		buf.signal()
+	} else if err := buf.dropSeals(); err != nil {
+		log.Warn("db buffer seals", zap.Error(err))
+	}
+	return buf
+}
+
+func (buf *writeBuffer) signal() {
+	select {
+	case buf.flushCh <- struct{}{}:
+	default:
+	}
+}
+
+// put возвращает итог как прямая запись: для счетчика это известный итог
+// в БД плюс буферизованная дельта.
+func (buf *writeBuffer) put(cx ctx.Context, met *s.Metrics) (*s.Metrics, error) {
+	if err := buf.clearApplied(cx); err != nil {
+		return nil, err
+	}
+	buf.mtx.RLock()
+	defer buf.mtx.RUnlock()
+	m, err := buf.store.Put(cx, met)
+	if err != nil {
+		return nil, fmt.Errorf("db buffer put: %w", err)
+	}
+	buf.pending.Store(true)
+	return buf.withTotal(nil, m), nil
+}
+
+func (buf *writeBuffer) putBatch(cx ctx.Context, mets []*s.Metrics) error {
+	if err := buf.clearApplied(cx); err != nil {
+		return err
+	}
+	buf.mtx.RLock()
+	defer buf.mtx.RUnlock()
+	// в буфер кладутся копии: итоги подставляются в метрики вызывающего
+	totals := make([]*s.Metrics, len(mets))
+	for i, met := range mets {
+		m, err := buf.store.Put(cx, copyMetric(met))
+		if err != nil {
+			return fmt.Errorf("db buffer put: %w", err)
+		}
+		totals[i] = buf.withTotal(nil, m)
+	}
+	buf.pending.Store(true)
+	for i, met := range mets {
+		if met.IsCounter() {
+			met.Delta = totals[i].Delta
+		}
+	}
+	return nil
+}
+
+// buffered сообщает, что в буфере есть несброшенные записи: пока это так,
+// новые записи тоже идут в буфер, чтобы не обогнать старые значения gauge.
+func (db *DataBase) buffered() bool {
+	return db.buffer != nil && db.buffer.pending.Load()
+}
+
+// mergeBuffered накладывает буферизованное значение на значение из БД.
+// dbMet может быть nil, если метрики в БД еще нет или БД недоступна.
+func (db *DataBase) mergeBuffered(cx ctx.Context, dbMet *s.Metrics, key *s.Metrics) (*s.Metrics, bool) {
+	db.buffer.mtx.RLock()
+	bufMet, err := db.buffer.store.Get(cx, key)
+	db.buffer.mtx.RUnlock()
+	if err != nil {
+		return dbMet, false
+	}
+	return db.buffer.withTotal(dbMet, bufMet), true
+}
+
+func (db *DataBase) listBuffered(cx ctx.Context, dbMets []*s.Metrics) []*s.Metrics {
+	db.buffer.mtx.RLock()
+	bufMets, _ := db.buffer.store.List(cx)
+	db.buffer.mtx.RUnlock()
+	if len(bufMets) == 0 {
+		return dbMets
+	}
+	idx := make(map[memKey]int, len(dbMets))
+	for i, m := range dbMets {
+		idx[keyOf(m)] = i
+	}
+	for _, bm := range bufMets {
+		if i, ok := idx[keyOf(bm)]; ok {
+			dbMets[i] = mergeMetric(dbMets[i], bm)
+			continue
+		}
+		dbMets = append(dbMets, db.buffer.withTotal(nil, bm))
+	}
+	return dbMets
+}
+
+// remember запоминает итог счетчика, прочитанный из БД или записанный в
+// нее: пока БД недоступна, к нему прибавляются буферизованные дельты.
+func (buf *writeBuffer) remember(met *s.Metrics) {
+	if met == nil || !met.IsCounter() || met.Delta == nil {
+		return
+	}
+	buf.totalsMtx.Lock()
+	buf.totals[met.ID] = *met.Delta
+	buf.totalsMtx.Unlock()
+}
+
+// withTotal накладывает буферизованное значение на значение из БД, а если
+// его нет - на последний известный итог. bufMet не меняется.
+func (buf *writeBuffer) withTotal(dbMet, bufMet *s.Metrics) *s.Metrics {
+	if dbMet == nil && bufMet.IsCounter() {
+		buf.totalsMtx.Lock()
+		total, ok := buf.totals[bufMet.ID]
+		buf.totalsMtx.Unlock()
+		if ok {
+			dbMet = &s.Metrics{ID: bufMet.ID, MType: s.Counter, Delta: &total}
+		}
+	}
+	return mergeMetric(dbMet, bufMet)
+}
+
+// mergeMetric не меняет bufMet: это значение, хранимое в буфере.
+func mergeMetric(dbMet, bufMet *s.Metrics) *s.Metrics {
+	res := copyMetric(bufMet)
+	if dbMet != nil && res.IsCounter() && dbMet.Delta != nil {
+		total := *dbMet.Delta + *res.Delta
+		res.Delta = &total
+	}
+	return res
+}
+
+func copyMetric(met *s.Metrics) *s.Metrics {
+	res := &s.Metrics{ID: met.ID, MType: met.MType}
+	if met.Delta != nil {
+		delta := *met.Delta
+		res.Delta = &delta
+	}
+	if met.Value != nil {
+		val := *met.Value
+		res.Value = &val
+	}
+	return res
+}
+
+// runFlusher сбрасывает буфер при замыкании цепи и периодически - на
+// случай, если единичная ошибка соединения не разомкнула ее.
+func (db *DataBase) runFlusher(cx ctx.Context) {
+	if db.buffer == nil {
+		return
+	}
+	ticker := time.NewTicker(breakerCooldown)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cx.Done():
+			db.buffer.store.Close()
+			return
+		case <-ticker.C:
+		case <-db.buffer.flushCh:
+		}
+		if err := db.flush(cx); err != nil {
+			log.Warn("db buffer flush error", zap.Error(err))
+		}
+	}
+}
+
+// flush переносит буфер в БД одной транзакцией.
+func (db *DataBase) flush(cx ctx.Context) error {
+	if !db.buffered() || db.breaker.State() == stateOpen {
+		return nil
+	}
+	cx, cancel := ctx.WithTimeout(cx, flushTimeout)
+	defer cancel()
+	return db.buffer.flush(cx, db.putBatchOnce, db.forgetFlushes)
+}
+
+// flush применяет буфер через apply и очищает его, после чего удаляет
+// token'ы печатей через forget. Запись в буфер на это время блокируется,
+// чтобы ничего не потерялось между List и reset.
+func (buf *writeBuffer) flush(cx ctx.Context, apply applyFunc, forget forgetFunc) error {
+	buf.mtx.Lock()
+	defer buf.mtx.Unlock()
+	stored, err := buf.store.List(cx)
+	if err != nil {
+		return fmt.Errorf("db buffer list: %w", err)
+	}
+	if len(stored) > 0 {
+		mets := make([]*s.Metrics, len(stored))
+		for i, m := range stored {
+			mets[i] = copyMetric(m)
+		}
+		seal, err := newFlushSeal(mets)
+		if err != nil {
+			return err
+		}
+		// печать сохраняется до отправки: исход попытки может остаться
+		// неизвестным (обрыв после COMMIT или падение процесса)
+		if err := buf.saveSeals(append(buf.seals, seal)); err != nil {
+			return err
+		}
+		err = apply(cx, mets, seal.Token, buf.seals[:len(buf.seals)-1])
+		if errors.Is(err, errBatchNotSent) {
+			_ = buf.saveSeals(buf.seals[:len(buf.seals)-1])
+		}
+		if err != nil {
+			return err
+		}
+		buf.addTotals(stored, len(buf.seals) == 1)
+	}
+	// данные уже в БД: новые записи можно писать напрямую
+	buf.applied.Store(true)
+	buf.pending.Store(false)
+	if err := buf.clear(cx); err != nil {
+		return err
+	}
+	log.Info("db buffer flushed", zap.Int("metrics", len(stored)))
+	// не удаленные token'ы только занимают место: повторим при следующем сбросе
+	if len(buf.stale) > 0 {
+		if err := forget(cx, buf.stale); err != nil {
+			log.Warn("db buffer flush tokens are kept", zap.Error(err))
+		} else {
+			buf.stale = nil
+		}
+	}
+	return nil
+}
+
+// addTotals переносит сброшенные дельты в известные итоги. Если были
+// попытки с неизвестным исходом, точный прирост неизвестен - итоги
+// забываются до следующего чтения из БД.
+func (buf *writeBuffer) addTotals(flushed []*s.Metrics, exact bool) {
+	buf.totalsMtx.Lock()
+	defer buf.totalsMtx.Unlock()
+	for _, m := range flushed {
+		if !m.IsCounter() {
+			continue
+		}
+		if total, ok := buf.totals[m.ID]; ok && exact {
+			buf.totals[m.ID] = total + *m.Delta
+		} else {
+			delete(buf.totals, m.ID)
+		}
+	}
+}
+
+// clearApplied дочищает буфер, который уже применен к БД: иначе новые
+// записи смешались бы с ним и были бы сброшены повторно.
+func (buf *writeBuffer) clearApplied(cx ctx.Context) error {
+	if !buf.applied.Load() {
+		return nil
+	}
+	buf.mtx.Lock()
+	defer buf.mtx.Unlock()
+	return buf.clear(cx)
+}
+
+// clear вызывается под buf.mtx.
+func (buf *writeBuffer) clear(cx ctx.Context) error {
+	if !buf.applied.Load() {
+		return nil
+	}
+	if err := buf.store.reset(cx); err != nil {
+		return fmt.Errorf("db buffer reset: %w", err)
+	}
+	if err := buf.dropSeals(); err != nil {
+		return err
+	}
+	buf.applied.Store(false)
+	return nil
+}
+
+// flushSeal - попытка сброса: token, который БД фиксирует в транзакции
+// сброса, и отправленное содержимое буфера. Буфер между попытками только
+// растет, поэтому каждая следующая печать включает предыдущие.
+type flushSeal struct {
+	Token   string       `json:"token"`
+	Metrics []*s.Metrics `json:"metrics"`
+}
+
+func newFlushSeal(mets []*s.Metrics) (flushSeal, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return flushSeal{}, fmt.Errorf("db buffer token: %w", err)
+	}
+	return flushSeal{Token: hex.EncodeToString(b[:]), Metrics: mets}, nil
+}
+
+// saveSeals сохраняет попытки с неизвестным исходом рядом с файловым
+// буфером. Вызывается под buf.mtx.
+func (buf *writeBuffer) saveSeals(seals []flushSeal) error {
+	if buf.sealPath != "" {
+		var err error
+		if len(seals) == 0 {
+			err = os.Remove(buf.sealPath)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		} else {
+			var data []byte
+			if data, err = json.Marshal(seals); err == nil {
+				err = writeSynced(buf.sealPath, data)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("db buffer seals: %w", err)
+		}
+	}
+	buf.seals = seals
+	return nil
+}
+
+// dropSeals забывает печати, когда их исход больше не важен: буфер
+// очищен или пуст. Вызывается под buf.mtx.
+func (buf *writeBuffer) dropSeals() error {
+	seals := buf.seals
+	if err := buf.saveSeals(nil); err != nil {
+		return err
+	}
+	for _, seal := range seals {
+		buf.stale = append(buf.stale, seal.Token)
+	}
+	return nil
+}
+
+func loadSeals(path string) ([]flushSeal, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("db buffer seals: %w", err)
+	}
+	var seals []flushSeal
+	if err := json.Unmarshal(data, &seals); err != nil {
+		return nil, fmt.Errorf("db buffer seals: %w", err)
+	}
+	return seals, nil
+}
+
+// unapplied возвращает часть mets, еще не примененную к БД, если
+// примененной оказалась печать base: дельты счетчиков уменьшаются на
+// отправленные, gauge передаются как есть - их запись идемпотентна.
+func unapplied(mets, base []*s.Metrics) []*s.Metrics {
+	sent := make(map[memKey]int64, len(base))
+	for _, m := range base {
+		if m.IsCounter() {
+			sent[keyOf(m)] = *m.Delta
+		}
+	}
+	res := make([]*s.Metrics, 0, len(mets))
+	for _, m := range mets {
+		if m.IsCounter() {
+			delta := *m.Delta - sent[keyOf(m)]
+			if delta == 0 {
+				continue
+			}
+			m = &s.Metrics{ID: m.ID, MType: m.MType, Delta: &delta}
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, permissions)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func isBufferable(err error) bool {
+	return errors.Is(err, ErrConnDB)
+}
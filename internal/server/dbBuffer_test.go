@@ -0,0 +1,164 @@
+package server
+
+import (
+	ctx "context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	s "metrics/internal/service"
+)
+
+func TestDBBufferMerge(t *testing.T) {
+	cx := ctx.Background()
+	db := &DataBase{breaker: newCircuitBreaker(), buffer: newWriteBuffer(NewMemStore())}
+
+	delta, val := int64(5), 1.5
+	for i := 0; i < 2; i++ {
+		d := delta
+		if _, err := db.buffer.put(cx, &s.Metrics{ID: "c", MType: s.Counter, Delta: &d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = db.buffer.putBatch(cx, []*s.Metrics{{ID: "g", MType: s.Gauge, Value: &val}})
+	if !db.buffered() {
+		t.Fatal("buffer is not pending")
+	}
+
+	dbTotal, dbGauge := int64(100), 7.0
+	got, ok := db.mergeBuffered(cx, &s.Metrics{ID: "c", MType: s.Counter, Delta: &dbTotal},
+		&s.Metrics{ID: "c", MType: s.Counter})
+	if !ok || *got.Delta != 110 {
+		t.Fatalf("merged counter = %v, %v", got, ok)
+	}
+
+	list := db.listBuffered(cx, []*s.Metrics{
+		{ID: "c", MType: s.Counter, Delta: &dbTotal},
+		{ID: "g", MType: s.Gauge, Value: &dbGauge},
+		{ID: "c", MType: s.Gauge, Value: &dbGauge},
+	})
+	if len(list) != 3 || *list[0].Delta != 110 || *list[1].Value != val || *list[2].Value != dbGauge {
+		t.Fatalf("merged list = %v", list)
+	}
+	// буфер не должен меняться при слиянии
+	if m, _ := db.buffer.store.Get(cx, &s.Metrics{ID: "c", MType: s.Counter}); *m.Delta != 10 {
+		t.Fatalf("buffered counter changed: %d", *m.Delta)
+	}
+}
+
+// fakeFlushDB повторяет протокол putBatchOnce: token фиксируется вместе с
+// данными, из повторной попытки вычитается примененная печать.
+type fakeFlushDB struct {
+	tokens   map[string]bool
+	counters map[string]int64
+	lostAck  bool // коммит проходит, но ответ теряется
+	downDel  bool // удаление token'ов не проходит
+}
+
+func (f *fakeFlushDB) apply(_ ctx.Context, mets []*s.Metrics, token string, seals []flushSeal) error {
+	for i := len(seals) - 1; i >= 0; i-- {
+		if f.tokens[seals[i].Token] {
+			mets = unapplied(mets, seals[i].Metrics)
+			break
+		}
+	}
+	f.tokens[token] = true
+	for _, m := range mets {
+		if m.IsCounter() {
+			f.counters[m.ID] += *m.Delta
+		}
+	}
+	if f.lostAck {
+		f.lostAck = false
+		return errors.New("connection reset")
+	}
+	return nil
+}
+
+func (f *fakeFlushDB) forget(_ ctx.Context, tokens []string) error {
+	if f.downDel {
+		f.downDel = false
+		return errors.New("connection reset")
+	}
+	for _, token := range tokens {
+		delete(f.tokens, token)
+	}
+	return nil
+}
+
+func TestDBBufferFlushOnce(t *testing.T) {
+	cx := ctx.Background()
+	path := filepath.Join(t.TempDir(), "buffer.json")
+	db := &fakeFlushDB{tokens: map[string]bool{}, counters: map[string]int64{}}
+	counter := func(delta int64) *s.Metrics {
+		return &s.Metrics{ID: "c", MType: s.Counter, Delta: &delta}
+	}
+
+	buf := openWriteBuffer(cx, path)
+	_, _ = buf.put(cx, counter(5))
+	db.lostAck = true
+	if err := buf.flush(cx, db.apply, db.forget); err == nil {
+		t.Fatal("expected flush error")
+	}
+	_, _ = buf.put(cx, counter(3))
+	buf.store.Close()
+
+	// перезапуск: исход первой попытки неизвестен, печать на диске
+	buf = openWriteBuffer(cx, path)
+	if err := buf.flush(cx, db.apply, db.forget); err != nil {
+		t.Fatal(err)
+	}
+	if db.counters["c"] != 8 {
+		t.Fatalf("counter flushed twice: %d", db.counters["c"])
+	}
+	if _, err := os.Stat(path + sealSuffix); !os.IsNotExist(err) {
+		t.Fatalf("seals must be removed after flush: %v", err)
+	}
+	if mets, _ := buf.store.List(cx); len(mets) != 0 {
+		t.Fatalf("buffer not cleared: %v", mets)
+	}
+	if len(db.tokens) != 0 {
+		t.Fatalf("flush tokens are not deleted: %v", db.tokens)
+	}
+
+	// буферизованная запись возвращает итог, как и прямая
+	buf.remember(counter(db.counters["c"]))
+	got, err := buf.put(cx, counter(2))
+	if err != nil || *got.Delta != 10 {
+		t.Fatalf("buffered put = %v, %v; want total 10", got, err)
+	}
+	batch := []*s.Metrics{counter(1)}
+	if err := buf.putBatch(cx, batch); err != nil || *batch[0].Delta != 11 {
+		t.Fatalf("buffered batch = %d, %v; want total 11", *batch[0].Delta, err)
+	}
+	buf.store.Close()
+}
+
+func TestDBBufferForgetTokens(t *testing.T) {
+	cx := ctx.Background()
+	db := &fakeFlushDB{tokens: map[string]bool{}, counters: map[string]int64{}}
+	buf := openWriteBuffer(cx, "")
+	delta := int64(1)
+
+	_, _ = buf.put(cx, &s.Metrics{ID: "c", MType: s.Counter, Delta: &delta})
+	db.downDel = true
+	if err := buf.flush(cx, db.apply, db.forget); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.tokens) != 1 || len(buf.stale) != 1 {
+		t.Fatalf("tokens = %v, stale = %v; want one kept token", db.tokens, buf.stale)
+	}
+
+	// следующий сброс удаляет и прежний token
+	_, _ = buf.put(cx, &s.Metrics{ID: "c", MType: s.Counter, Delta: &delta})
+	if err := buf.flush(cx, db.apply, db.forget); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.tokens) != 0 || len(buf.stale) != 0 {
+		t.Fatalf("tokens = %v, stale = %v; want none", db.tokens, buf.stale)
+	}
+	if db.counters["c"] != 2 {
+		t.Fatalf("counter = %d, want 2", db.counters["c"])
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	ctx "context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "metrics/internal/logger"
+	s "metrics/internal/service"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+const (
+	partitionsAhead     = 3
+	partitionDayFormat  = "20060102"
+	maintenanceInterval = time.Hour
+)
+
+var sampleTables = []string{"gauge_samples", "counter_samples"}
+
+const (
+	sampleGaugeRow = `INSERT INTO gauge_samples(id, ts, value)
+		SELECT id, now(), value FROM gauge WHERE id = $1`
+
+	sampleCounterRow = `INSERT INTO counter_samples(id, ts, value)
+		SELECT id, now(), value FROM counter WHERE id = $1`
+
+	sampleGaugeBatch = `INSERT INTO gauge_samples(id, ts, value)
+		SELECT id, now(), value FROM gauge
+		WHERE id IN (SELECT id FROM ` + batchTable + ` WHERE mtype = 'gauge')`
+
+	sampleCounterBatch = `INSERT INTO counter_samples(id, ts, value)
+		SELECT id, now(), value FROM counter
+		WHERE id IN (SELECT id FROM ` + batchTable + ` WHERE mtype = 'counter')`
+
+	// условие по ts позволяет планировщику отсечь лишние партиции
+	selectGaugeHistory = `SELECT ts, value FROM gauge_samples
+		WHERE id = $1 AND ts >= $2 AND ts < $3 ORDER BY ts`
+
+	selectCounterHistory = `SELECT ts, value::double precision FROM counter_samples
+		WHERE id = $1 AND ts >= $2 AND ts < $3 ORDER BY ts`
+
+	// точки, попавшие в DEFAULT-партицию, переносятся в новую дневную:
+	// иначе CREATE ... PARTITION OF упадет на нарушении ограничения
+	createMoveTable = `CREATE TEMP TABLE partition_move (LIKE %s) ON COMMIT DROP`
+	moveFromDefault = `WITH moved AS (
+			DELETE FROM %s WHERE ts >= $1 AND ts < $2 RETURNING *
+		) INSERT INTO partition_move SELECT * FROM moved`
+	moveBack = `INSERT INTO %s SELECT * FROM partition_move`
+
+	deleteExpiredDefault = `DELETE FROM %s WHERE ts < $1`
+
+	selectPartitions = `SELECT c.relname FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = $1`
+)
+
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// historian реализуют хранилища, которые умеют отдавать историю значений.
+type historian interface {
+	History(cx ctx.Context, met *s.Metrics, from, to time.Time) ([]Sample, error)
+}
+
+func sampleQuery(met *s.Metrics) string {
+	if met.IsGauge() {
+		return sampleGaugeRow
+	}
+	return sampleCounterRow
+}
+
+// EnableHistory включает запись истории в партиционированные по дням
+// таблицы *_samples и сразу создает партиции на ближайшие дни.
+func (db *DataBase) EnableHistory(cx ctx.Context, retentionDays int) error {
+	db.historyDays = retentionDays
+	if err := db.maintainPartitions(cx, time.Now()); err != nil {
+		return fmt.Errorf("enable history: %w", err)
+	}
+	return nil
+}
+
+func (db *DataBase) historyEnabled() bool {
+	return db.historyDays > 0
+}
+
+func (db *DataBase) History(cx ctx.Context, met *s.Metrics, from, to time.Time) ([]Sample, error) {
+	if !db.historyEnabled() {
+		return nil, ErrNoValue
+	}
+	conn, err := db.conn(cx)
+	if err != nil {
+		return nil, fmt.Errorf("db history conn err: %w", err)
+	}
+	defer conn.Release()
+
+	query := selectCounterHistory
+	if met.IsGauge() {
+		query = selectGaugeHistory
+	}
+	var samples []Sample
+	rows, err := conn.Query(cx, query, met.ID, from, to)
+	if err == nil {
+		samples, err = pgx.CollectRows(rows, pgx.RowToStructByPos[Sample])
+	}
+	if err = db.check(err); err != nil {
+		return nil, fmt.Errorf("db history query err: %w", err)
+	}
+	return samples, nil
+}
+
+// runMaintenance раз в час создает будущие партиции и удаляет партиции
+// старше срока хранения.
+func (db *DataBase) runMaintenance(cx ctx.Context) {
+	if !db.historyEnabled() {
+		return
+	}
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := db.maintainPartitions(cx, now); err != nil {
+				log.Warn("partition maintenance error", zap.Error(err))
+			}
+		case <-cx.Done():
+			log.Debug("partition maintenance is done...")
+			return
+		}
+	}
+}
+
+func (db *DataBase) maintainPartitions(cx ctx.Context, now time.Time) error {
+	conn, err := db.conn(cx)
+	if err != nil {
+		return fmt.Errorf("maintenance conn err: %w", err)
+	}
+	defer conn.Release()
+	return db.check(db.maintain(cx, conn, now))
+}
+
+// maintain создает партиции на ближайшие дни и удаляет устаревшие. Если
+// обслуживание долго не выполнялось, точки пишутся в DEFAULT-партицию
+// (миграция 000005), и запись метрик не прерывается.
+func (db *DataBase) maintain(cx ctx.Context, conn *pgxpool.Conn, now time.Time) error {
+	today := now.UTC().Truncate(24 * time.Hour)
+	oldest := today.AddDate(0, 0, -db.historyDays)
+	for _, table := range sampleTables {
+		parts, err := listPartitions(cx, conn, table)
+		if err != nil {
+			return err
+		}
+		existing := make(map[string]bool, len(parts))
+		for _, part := range parts {
+			existing[part] = true
+		}
+		for i := 0; i <= partitionsAhead; i++ {
+			day := today.AddDate(0, 0, i)
+			if existing[partitionName(table, day)] {
+				continue
+			}
+			if err := createPartition(cx, conn, table, day); err != nil {
+				return err
+			}
+		}
+		expired := fmt.Sprintf(deleteExpiredDefault, pgx.Identifier{defaultPartition(table)}.Sanitize())
+		if _, err := conn.Exec(cx, expired, oldest); err != nil {
+			return fmt.Errorf("expire default partition: %w", err)
+		}
+		for _, part := range parts {
+			day, err := time.Parse(partitionDayFormat, strings.TrimPrefix(part, table+"_p"))
+			if err != nil || !day.Before(oldest) {
+				continue
+			}
+			if _, err := conn.Exec(cx, "DROP TABLE IF EXISTS "+pgx.Identifier{part}.Sanitize()); err != nil {
+				return fmt.Errorf("drop partition: %w", err)
+			}
+			log.Info("dropped expired partition", zap.String("partition", part))
+		}
+	}
+	return nil
+}
+
+func listPartitions(cx ctx.Context, conn *pgxpool.Conn, table string) ([]string, error) {
+	rows, err := conn.Query(cx, selectPartitions, table)
+	if err != nil {
+		return nil, fmt.Errorf("list partitions: %w", err)
+	}
+	parts, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("list partitions: %w", err)
+	}
+	return parts, nil
+}
+
+func createPartition(cx ctx.Context, conn *pgxpool.Conn, table string, day time.Time) error {
+	next := day.AddDate(0, 0, 1)
+	parent := pgx.Identifier{table}.Sanitize()
+	err := pgx.BeginFunc(cx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(cx, fmt.Sprintf(createMoveTable, parent)); err != nil {
+			return err
+		}
+		move := fmt.Sprintf(moveFromDefault, pgx.Identifier{defaultPartition(table)}.Sanitize())
+		if _, err := tx.Exec(cx, move, day, next); err != nil {
+			return err
+		}
+		create := fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')`,
+			partitionName(table, day), table, day.Format(time.RFC3339), next.Format(time.RFC3339))
+		if _, err := tx.Exec(cx, create); err != nil {
+			return err
+		}
+		_, err := tx.Exec(cx, fmt.Sprintf(moveBack, parent))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("create partition %s: %w", partitionName(table, day), err)
+	}
+	return nil
+}
+
+func defaultPartition(table string) string {
+	return table + "_default"
+}
+
+func partitionName(table string, day time.Time) string {
+	return table + "_p" + day.Format(partitionDayFormat)
+}
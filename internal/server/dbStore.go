@@ -4,8 +4,10 @@ import (
 	ctx "context"
 	"errors"
 	"fmt"
+	"time"
 
 	log "metrics/internal/logger"
+	"metrics/internal/selfstat"
 	s "metrics/internal/service"
 
 	"github.com/golang-migrate/migrate/v4"
@@ -13,6 +15,7 @@ import (
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
 )
 
 type dbOperation uint8
@@ -22,6 +25,45 @@ const (
 	selectMetric
 )
 
+const (
+	acquireTimeout     = 2 * time.Second
+	copyBatchThreshold = 32
+	batchTable         = "batch_metrics"
+)
+
+const (
+	selectFlushTokens = `SELECT token FROM buffer_flushes WHERE token = ANY($1)`
+	insertFlushToken  = `INSERT INTO buffer_flushes(token) VALUES($1)`
+	deleteFlushTokens = `DELETE FROM buffer_flushes WHERE token = ANY($1)`
+)
+
+const (
+	createBatchTable = `CREATE TEMP TABLE ` + batchTable + `(
+		seq   BIGINT NOT NULL,
+		mtype TEXT NOT NULL,
+		id    VARCHAR(255) NOT NULL,
+		delta BIGINT,
+		value DOUBLE PRECISION
+	) ON COMMIT DROP`
+
+	// дельты одного счетчика в батче суммируются до UPSERT
+	upsertCounterBatch = `INSERT INTO counter(id, value)
+		SELECT id, SUM(delta) FROM ` + batchTable + `
+		WHERE mtype = 'counter'
+		GROUP BY id
+		ON CONFLICT(id)
+		DO UPDATE SET value = counter.value + EXCLUDED.value
+		RETURNING id, value`
+
+	// для gauge побеждает последнее значение в батче
+	upsertGaugeBatch = `INSERT INTO gauge(id, value)
+		SELECT DISTINCT ON (id) id, value FROM ` + batchTable + `
+		WHERE mtype = 'gauge'
+		ORDER BY id, seq DESC
+		ON CONFLICT(id)
+		DO UPDATE SET value = EXCLUDED.value`
+)
+
 const (
 	insertCounter = "insertCounter"
 	insertGauge   = "insertGauge"
@@ -32,10 +74,16 @@ const (
 
 type DataBase struct {
 	*pgxpool.Pool
+	breaker     *circuitBreaker
+	buffer      *writeBuffer
+	historyDays int
 }
 
 var ErrConnDB = errors.New("db connection error")
 
+// errBatchNotSent - батч не отправлен: соединение не получено.
+var errBatchNotSent = errors.New("batch was not sent")
+
 func NewDB(cx ctx.Context, addr string) (*DataBase, error) {
 	if err := runMigrations(addr); err != nil {
 		return nil, err
@@ -44,18 +92,37 @@ func NewDB(cx ctx.Context, addr string) (*DataBase, error) {
 	if err != nil {
 		return nil, fmt.Errorf("newDB: unable to parse connection string: %w", err)
 	}
+	// запросы готовятся на каждом новом соединении пула
+	config.AfterConnect = prepareQueries
+	config.ConnConfig.Tracer = pgxTracer{}
 	pool, err := pgxpool.NewWithConfig(cx, config)
 	if err != nil {
 		return nil, fmt.Errorf("newDB: unable to create connection pool: %w", err)
 	}
-	if err := prepareQueries(cx, pool); err != nil {
+	return &DataBase{
+		Pool:    pool,
+		breaker: newCircuitBreaker(),
+	}, nil
+}
+
+func (db *DataBase) Put(cx ctx.Context, met *s.Metrics) (*s.Metrics, error) {
+	if err := validateMetric(met); err != nil {
 		return nil, err
 	}
-	return &DataBase{pool}, nil
+	if db.buffered() {
+		db.buffer.signal()
+		return db.buffer.put(cx, met)
+	}
+	m, err := db.put(cx, met)
+	if db.buffer != nil && isBufferable(err) {
+		log.Ctx(cx).Warn("db unavailable, buffering update", zap.String("id", met.ID), zap.Error(err))
+		return db.buffer.put(cx, met)
+	}
+	return m, err
 }
 
-func (db *DataBase) Put(cx ctx.Context, met *s.Metrics) (*s.Metrics, error) {
-	conn, err := db.connectWithRetry(cx)
+func (db *DataBase) put(cx ctx.Context, met *s.Metrics) (*s.Metrics, error) {
+	conn, err := db.conn(cx)
 	if err != nil {
 		return nil, fmt.Errorf("db put conn err: %w", err)
 	}
@@ -63,15 +130,42 @@ func (db *DataBase) Put(cx ctx.Context, met *s.Metrics) (*s.Metrics, error) {
 
 	var val any
 	query := getQuery(insertMetric, met)
-	if err = conn.QueryRow(cx, query, met.ToSlice()...).Scan(&val); err != nil {
+	if db.historyEnabled() {
+		// значение и точка истории в одной транзакции: иначе при ошибке
+		// вставки точки повтор запроса учел бы дельту счетчика дважды
+		err = pgx.BeginFunc(cx, conn, func(tx pgx.Tx) error {
+			if err := tx.QueryRow(cx, query, met.ToSlice()...).Scan(&val); err != nil {
+				return err
+			}
+			_, err := tx.Exec(cx, sampleQuery(met), met.ID)
+			return err
+		})
+	} else {
+		err = conn.QueryRow(cx, query, met.ToSlice()...).Scan(&val)
+	}
+	if err = db.check(err); err != nil {
 		return nil, fmt.Errorf("db put queryRow error: %w", err)
 	}
 	setVal(met, val)
+	if db.buffer != nil {
+		db.buffer.remember(met)
+	}
 	return met, nil
 }
 
 func (db *DataBase) Get(cx ctx.Context, met *s.Metrics) (*s.Metrics, error) {
-	conn, err := db.connectWithRetry(cx)
+	m, err := db.get(cx, met)
+	if !db.buffered() || (err != nil && !isBufferable(err) && !errors.Is(err, pgx.ErrNoRows)) {
+		return m, err
+	}
+	if merged, ok := db.mergeBuffered(cx, m, met); ok {
+		return merged, nil
+	}
+	return m, err
+}
+
+func (db *DataBase) get(cx ctx.Context, met *s.Metrics) (*s.Metrics, error) {
+	conn, err := db.conn(cx)
 	if err != nil {
 		return nil, fmt.Errorf("db get conn err: %w", err)
 	}
@@ -79,77 +173,305 @@ func (db *DataBase) Get(cx ctx.Context, met *s.Metrics) (*s.Metrics, error) {
 
 	query := getQuery(selectMetric, met)
 	var val any
-	if err = conn.QueryRow(cx, query, met.ID).Scan(&val); err != nil {
+	err = conn.QueryRow(cx, query, met.ID).Scan(&val)
+	if err = db.check(err); err != nil {
 		return nil, fmt.Errorf("db get failed to execute query: %w", err)
 	}
 	setVal(met, val)
+	if db.buffer != nil {
+		db.buffer.remember(met)
+	}
 	return met, nil
 }
 
-func (db *DataBase) List(cx ctx.Context) (metrics []*s.Metrics, err error) {
-	conn, err := db.connectWithRetry(cx)
+func (db *DataBase) List(cx ctx.Context) ([]*s.Metrics, error) {
+	metrics, err := db.list(cx)
+	if !db.buffered() || (err != nil && !isBufferable(err)) {
+		return metrics, err
+	}
+	return db.listBuffered(cx, metrics), nil
+}
+
+func (db *DataBase) list(cx ctx.Context) (metrics []*s.Metrics, err error) {
+	conn, err := db.conn(cx)
 	if err != nil {
 		return nil, fmt.Errorf("db list conn err: %w", err)
 	}
 	defer conn.Release()
 
 	rows, err := conn.Query(cx, selectAll)
-	if err != nil {
+	if err = db.check(err); err != nil {
 		return nil, fmt.Errorf("dbList query err: %w", err)
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var met s.Metrics
-		var val any
-		if err := rows.Scan(&met.ID, &val); err != nil {
+		if err := rows.Scan(&met.ID, &met.Value, &met.Delta); err != nil {
 			return nil, fmt.Errorf("dbList query scan err: %w", err)
 		}
-		setVal(&met, val)
+		met.MType = s.Gauge
+		if met.Delta != nil {
+			met.MType = s.Counter
+		}
 		metrics = append(metrics, &met)
 	}
 	if err := rows.Err(); err != nil {
+		if isConnError(err) {
+			db.breaker.report(err)
+			err = fmt.Errorf("%w: %w", ErrConnDB, err)
+		}
 		return nil, fmt.Errorf("dbList query rows error: %w", err)
 	}
 	return metrics, nil
 }
 
+// PutBatch применяет батч одной транзакцией. Крупные батчи копируются
+// через COPY во временную таблицу и применяются одним INSERT ... SELECT
+// на тип с предварительной агрегацией дельт счетчиков.
 func (db *DataBase) PutBatch(cx ctx.Context, mets []*s.Metrics) error {
-	conn, err := db.connectWithRetry(cx)
+	for _, met := range mets {
+		if err := validateMetric(met); err != nil {
+			return err
+		}
+	}
+	if db.buffered() {
+		db.buffer.signal()
+		return db.buffer.putBatch(cx, mets)
+	}
+	err := db.putBatch(cx, mets)
+	if db.buffer != nil && isBufferable(err) {
+		log.Ctx(cx).Warn("db unavailable, buffering batch", zap.Int("size", len(mets)), zap.Error(err))
+		return db.buffer.putBatch(cx, mets)
+	}
+	return err
+}
+
+// putBatch, как и put, заменяет дельты счетчиков итогами из БД, но только
+// после коммита.
+func (db *DataBase) putBatch(cx ctx.Context, mets []*s.Metrics) error {
+	totals, err := db.applyInTx(cx, mets, nil)
+	if err != nil {
+		return err
+	}
+	for _, met := range mets {
+		if total, ok := totals[met.ID]; ok && met.IsCounter() {
+			met.Delta = &total
+			if db.buffer != nil {
+				db.buffer.remember(met)
+			}
+		}
+	}
+	return nil
+}
+
+// putBatchOnce применяет сброс буфера записи (см. applyFunc): token
+// фиксируется в той же транзакции, а из mets вычитается уже примененная
+// печать прежней попытки.
+func (db *DataBase) putBatchOnce(cx ctx.Context, mets []*s.Metrics, token string, seals []flushSeal) error {
+	_, err := db.applyInTx(cx, mets, func(tx pgx.Tx, mets []*s.Metrics) ([]*s.Metrics, error) {
+		if len(seals) > 0 {
+			tokens := make([]string, len(seals))
+			for i, seal := range seals {
+				tokens[i] = seal.Token
+			}
+			applied := make(map[string]bool, len(seals))
+			rows, err := tx.Query(cx, selectFlushTokens, tokens)
+			if err != nil {
+				return nil, fmt.Errorf("select flush tokens: %w", err)
+			}
+			for rows.Next() {
+				var token string
+				if err := rows.Scan(&token); err != nil {
+					rows.Close()
+					return nil, fmt.Errorf("scan flush token: %w", err)
+				}
+				applied[token] = true
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return nil, fmt.Errorf("select flush tokens: %w", err)
+			}
+			// печати вложены друг в друга: важна последняя примененная
+			for i := len(seals) - 1; i >= 0; i-- {
+				if applied[seals[i].Token] {
+					mets = unapplied(mets, seals[i].Metrics)
+					break
+				}
+			}
+		}
+		if _, err := tx.Exec(cx, insertFlushToken, token); err != nil {
+			return nil, fmt.Errorf("insert flush token: %w", err)
+		}
+		return mets, nil
+	})
+	return err
+}
+
+// forgetFlushes удаляет token'ы сбросов, печати которых уже не хранятся.
+func (db *DataBase) forgetFlushes(cx ctx.Context, tokens []string) error {
+	conn, err := db.conn(cx)
 	if err != nil {
-		return fmt.Errorf("putBatch err: %w", err)
+		return fmt.Errorf("delete flush tokens: %w", err)
 	}
 	defer conn.Release()
+	_, err = conn.Exec(cx, deleteFlushTokens, tokens)
+	if err = db.check(err); err != nil {
+		return fmt.Errorf("delete flush tokens: %w", err)
+	}
+	return nil
+}
+
+// batchPrepare выполняется в транзакции батча до записи метрик и может
+// заменить батч.
+type batchPrepare func(tx pgx.Tx, mets []*s.Metrics) ([]*s.Metrics, error)
 
+// applyInTx возвращает итоги затронутых счетчиков по ID.
+func (db *DataBase) applyInTx(cx ctx.Context, mets []*s.Metrics, prepare batchPrepare) (map[string]int64, error) {
+	start := time.Now()
+	conn, err := db.conn(cx)
+	if err != nil {
+		return nil, fmt.Errorf("putBatch err: %w: %w", errBatchNotSent, err)
+	}
+	defer conn.Release()
+
+	mode, totals, err := db.applyBatch(cx, conn, mets, prepare)
+	if err = db.check(err); err != nil {
+		return nil, fmt.Errorf("putBatch err: %w", err)
+	}
+	latency := time.Since(start)
+	selfstat.Inc(selfstat.Name("db", "batch", mode, "calls"))
+	selfstat.Add(selfstat.Name("db", "batch", mode, "latency_us"), latency.Microseconds())
+	log.Ctx(cx).Debug("db batch applied",
+		zap.String("mode", mode),
+		zap.Int("size", len(mets)),
+		zap.Duration("latency", latency))
+	return totals, nil
+}
+
+func (db *DataBase) applyBatch(cx ctx.Context, conn *pgxpool.Conn, mets []*s.Metrics,
+	prepare batchPrepare,
+) (string, map[string]int64, error) {
 	tx, err := conn.Begin(cx)
 	if err != nil {
-		return fmt.Errorf("failed transaction beginning: %w", err)
+		return "", nil, fmt.Errorf("failed transaction beginning: %w", err)
 	}
 	defer func() { _ = tx.Rollback(cx) }()
 
+	if prepare != nil {
+		if mets, err = prepare(tx, mets); err != nil {
+			return "", nil, err
+		}
+	}
+	mode := "rows"
+	var totals map[string]int64
+	switch {
+	case len(mets) == 0:
+		mode = "empty"
+	case len(mets) >= copyBatchThreshold:
+		mode = "copy"
+		totals, err = db.putBatchCopy(cx, tx, mets)
+	default:
+		totals, err = db.putBatchRows(cx, tx, mets)
+	}
+	if err != nil {
+		return mode, nil, err
+	}
+	if err := tx.Commit(cx); err != nil {
+		return mode, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return mode, totals, nil
+}
+
+func (db *DataBase) putBatchRows(cx ctx.Context, tx pgx.Tx, mets []*s.Metrics) (map[string]int64, error) {
 	batch := &pgx.Batch{}
 	for _, met := range mets {
 		batch.Queue(getQuery(insertMetric, met), met.ToSlice()...)
+		if db.historyEnabled() {
+			batch.Queue(sampleQuery(met), met.ID)
+		}
 	}
+	totals := make(map[string]int64)
 	br := tx.SendBatch(cx, batch)
-	if _, err := br.Exec(); err != nil {
-		return fmt.Errorf("batch exec failed: %w", err)
+	for _, met := range mets {
+		var val any
+		err := br.QueryRow().Scan(&val)
+		if err == nil && db.historyEnabled() {
+			_, err = br.Exec()
+		}
+		if err != nil {
+			_ = br.Close()
+			return nil, fmt.Errorf("batch exec failed: %w", err)
+		}
+		// для повторов счетчика в батче последний итог - окончательный
+		if total, ok := val.(int64); ok && met.IsCounter() {
+			totals[met.ID] = total
+		}
 	}
 	if err := br.Close(); err != nil {
-		return fmt.Errorf("failed to close batch res: %w", err)
+		return nil, fmt.Errorf("failed to close batch res: %w", err)
 	}
-	if err := tx.Commit(cx); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
-	}
-	return nil
+	return totals, nil
 }
 
-func prepareQueries(cx ctx.Context, pool *pgxpool.Pool) error {
-	conn, err := pool.Acquire(cx)
+func (db *DataBase) putBatchCopy(cx ctx.Context, tx pgx.Tx, mets []*s.Metrics) (map[string]int64, error) {
+	if _, err := tx.Exec(cx, createBatchTable); err != nil {
+		return nil, fmt.Errorf("batch temp table: %w", err)
+	}
+	rows := make([][]any, len(mets))
+	for i, met := range mets {
+		rows[i] = []any{int64(i), met.MType, met.ID, met.Delta, met.Value}
+	}
+	_, err := tx.CopyFrom(cx, pgx.Identifier{batchTable},
+		[]string{"seq", "mtype", "id", "delta", "value"},
+		pgx.CopyFromRows(rows))
 	if err != nil {
-		return fmt.Errorf("prepareQueries conn err: %w", err)
+		return nil, fmt.Errorf("batch copy: %w", err)
 	}
-	defer conn.Release()
+	upserted, err := tx.Query(cx, upsertCounterBatch)
+	if err != nil {
+		return nil, fmt.Errorf("batch counter upsert: %w", err)
+	}
+	totals := make(map[string]int64)
+	for upserted.Next() {
+		var (
+			id    string
+			total int64
+		)
+		if err := upserted.Scan(&id, &total); err != nil {
+			upserted.Close()
+			return nil, fmt.Errorf("batch counter upsert scan: %w", err)
+		}
+		totals[id] = total
+	}
+	upserted.Close()
+	if err := upserted.Err(); err != nil {
+		return nil, fmt.Errorf("batch counter upsert: %w", err)
+	}
+	if _, err := tx.Exec(cx, upsertGaugeBatch); err != nil {
+		return nil, fmt.Errorf("batch gauge upsert: %w", err)
+	}
+	if !db.historyEnabled() {
+		return totals, nil
+	}
+	for _, query := range []string{sampleGaugeBatch, sampleCounterBatch} {
+		if _, err := tx.Exec(cx, query); err != nil {
+			return nil, fmt.Errorf("batch samples: %w", err)
+		}
+	}
+	return totals, nil
+}
 
+func validateMetric(met *s.Metrics) error {
+	switch {
+	case met.IsCounter() && met.Delta != nil, met.IsGauge() && met.Value != nil:
+		return nil
+	case met.IsCounter(), met.IsGauge():
+		return fmt.Errorf("%w: %s has no value", s.ErrInvalidVal, met.ID)
+	}
+	return s.ErrInvalidType
+}
+
+func prepareQueries(cx ctx.Context, conn *pgx.Conn) error {
 	queries := map[string]string{
 		insertGauge: `INSERT INTO gauge(id, value) VALUES($1, $2) 
 			          ON CONFLICT(id) 
@@ -165,12 +487,12 @@ func prepareQueries(cx ctx.Context, pool *pgxpool.Pool) error {
 
 		selectCounter: `SELECT value FROM counter WHERE id = $1`,
 
-		selectAll: `SELECT id, value FROM gauge
+		selectAll: `SELECT id, value, NULL::bigint FROM gauge
 			        UNION ALL
-			        SELECT id, value FROM counter;`,
+			        SELECT id, NULL::double precision, value FROM counter;`,
 	}
 	for name, query := range queries {
-		if _, err = conn.Conn().Prepare(cx, name, query); err != nil {
+		if _, err := conn.Prepare(cx, name, query); err != nil {
 			return fmt.Errorf("prepareQueries %s err: %w", name, err)
 		}
 	}
@@ -189,24 +511,42 @@ func runMigrations(addr string) error {
 	return nil
 }
 
-func (db *DataBase) connectWithRetry(cx ctx.Context) (*pgxpool.Conn, error) {
-	conn, err := db.Acquire(cx)
-	if err != nil {
-		if err = s.Retry(cx, func() error {
-			conn, err = db.Acquire(cx)
-			return ErrConnDB
-		}); err != nil {
-			return nil, ErrConnDB
-		}
+// conn берет соединение из пула через circuit breaker: при разомкнутой
+// цепи отказ происходит сразу, без ожидания таймаутов. После успешного
+// conn результат операции нужно ровно один раз передать в check.
+func (db *DataBase) conn(cx ctx.Context) (*pgxpool.Conn, error) {
+	if err := db.breaker.allow(); err != nil {
+		return nil, err
 	}
-	if err = conn.Conn().Ping(cx); err != nil {
-		if err = s.Retry(cx, func() error {
-			err = conn.Conn().Ping(cx)
-			return ErrConnDB
-		}); err != nil {
-			return nil, ErrConnDB
-		}
+	acx, cancel := ctx.WithTimeout(cx, acquireTimeout)
+	defer cancel()
+	conn, err := db.Acquire(acx)
+	if err != nil {
+		db.breaker.report(err)
+		return nil, fmt.Errorf("%w: %w", ErrConnDB, err)
 	}
-	log.Debug("DB connection is established")
 	return conn, nil
 }
+
+// check сообщает результат breaker'у и помечает ошибки соединения ErrConnDB.
+func (db *DataBase) check(err error) error {
+	db.breaker.report(err)
+	if isConnError(err) && !errors.Is(err, ErrConnDB) {
+		return fmt.Errorf("%w: %w", ErrConnDB, err)
+	}
+	return err
+}
+
+// Check проверяет доступность БД; используется /ping.
+func (db *DataBase) Check(cx ctx.Context) error {
+	conn, err := db.conn(cx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	return db.check(conn.Ping(cx))
+}
+
+func (db *DataBase) CircuitState() string {
+	return db.breaker.State().String()
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	ctx "context"
+
+	"metrics/internal/tracing"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// pgxTracer открывает спан на каждый запрос, батч и COPY в pgx.
+type pgxTracer struct{}
+
+var (
+	_ pgx.QueryTracer    = pgxTracer{}
+	_ pgx.BatchTracer    = pgxTracer{}
+	_ pgx.CopyFromTracer = pgxTracer{}
+)
+
+func (pgxTracer) TraceQueryStart(cx ctx.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) ctx.Context {
+	cx, _ = tracing.Start(cx, "pgx.query",
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.statement", data.SQL))
+	return cx
+}
+
+func (pgxTracer) TraceQueryEnd(cx ctx.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(cx)
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	tracing.End(span, data.Err)
+}
+
+func (pgxTracer) TraceBatchStart(cx ctx.Context, _ *pgx.Conn, data pgx.TraceBatchStartData) ctx.Context {
+	cx, _ = tracing.Start(cx, "pgx.batch",
+		attribute.String("db.system", "postgresql"),
+		attribute.Int("db.batch_size", data.Batch.Len()))
+	return cx
+}
+
+func (pgxTracer) TraceBatchQuery(cx ctx.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	if data.Err != nil {
+		trace.SpanFromContext(cx).RecordError(data.Err)
+	}
+}
+
+func (pgxTracer) TraceBatchEnd(cx ctx.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	tracing.End(trace.SpanFromContext(cx), data.Err)
+}
+
+func (pgxTracer) TraceCopyFromStart(cx ctx.Context, _ *pgx.Conn, data pgx.TraceCopyFromStartData) ctx.Context {
+	cx, _ = tracing.Start(cx, "pgx.copy_from",
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.sql.table", data.TableName.Sanitize()))
+	return cx
+}
+
+func (pgxTracer) TraceCopyFromEnd(cx ctx.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	tracing.End(trace.SpanFromContext(cx), data.Err)
+}
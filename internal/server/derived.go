@@ -0,0 +1,77 @@
+package server
+
+import (
+	ctx "context"
+	"time"
+
+	"metrics/internal/derive"
+	log "metrics/internal/logger"
+	s "metrics/internal/service"
+
+	"go.uber.org/zap"
+)
+
+// derive пересчитывает производные метрики после записи и сохраняет их
+// как обычные gauge.
+func (mm *MetricManager) derive(cx ctx.Context, changed []*s.Metrics) {
+	if mm.Derived == nil {
+		return
+	}
+	keys := make([]derive.Key, len(changed))
+	for i, m := range changed {
+		keys[i] = derive.Key{Type: m.MType, ID: m.ID}
+	}
+	deps, all := mm.Derived.Deps(keys)
+	if len(deps) == 0 && !all {
+		return
+	}
+	vals, err := mm.deriveValues(cx, deps, all)
+	if err != nil {
+		log.Ctx(cx).Warn("derive(): storage error", zap.Error(err))
+		return
+	}
+	res := mm.Derived.Eval(time.Now(), keys, vals)
+	if len(res) == 0 {
+		return
+	}
+	derived := make([]*s.Metrics, 0, len(res))
+	for name, v := range res {
+		derived = append(derived, s.BuildMetric(name, v))
+	}
+	if err := mm.Storage.PutBatch(cx, derived); err != nil {
+		log.Ctx(cx).Warn("derive(): couldn't store derived metrics", zap.Error(err))
+		return
+	}
+	mm.updates.touch(derived...)
+	mm.broker.publish(derived...)
+}
+
+// deriveValues читает только нужные метрики; полный список - лишь для
+// выражений с шаблонами.
+func (mm *MetricManager) deriveValues(cx ctx.Context, deps []derive.Key, all bool) (derive.Values, error) {
+	vals := make(derive.Values, len(deps))
+	if all {
+		metrics, err := mm.Storage.List(cx)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range metrics {
+			if v, ok := metricValue(m); ok {
+				vals[derive.Key{Type: m.MType, ID: m.ID}] = v
+			}
+		}
+		return vals, nil
+	}
+	for _, k := range deps {
+		m, err := mm.Storage.Get(cx, &s.Metrics{ID: k.ID, MType: k.Type})
+		if isMissing(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if v, ok := metricValue(m); ok {
+			vals[k] = v
+		}
+	}
+	return vals, nil
+}
@@ -1,24 +1,60 @@
 package server
 
 import (
+	"bytes"
 	ctx "context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	log "metrics/internal/logger"
+	"metrics/internal/selfstat"
 	s "metrics/internal/service"
 
 	"github.com/pquerna/ffjson/ffjson"
 	"go.uber.org/zap"
 )
 
-const permissions = 0o666
+const (
+	permissions     = 0o666
+	walCompactAt    = 4 << 20 // размер WAL, после которого делается снимок
+	snapshotVersion = 2
+)
+
+var ErrSnapshotVersion = errors.New("unsupported snapshot version")
+
+// snapshot v2; v1 - голый массив метрик, где gauge и counter с одним id
+// затирали друг друга
+type snapshotFile struct {
+	Version int          `json:"version"`
+	WALSeq  uint64       `json:"wal_seq,omitempty"` // последняя вошедшая запись WAL
+	Metrics []*s.Metrics `json:"metrics"`
+}
 
+// FileStorage хранит снимок метрик в FilePath и журнал обновлений
+// (WAL) в FilePath.wal. Снимок периодически сжимает журнал.
 type FileStorage struct {
 	MemStorage
 	FilePath string
+	Backups  int
 	interval int
+	walMtx   *sync.Mutex
+	wal      *os.File
+	walSize  int64
+	walSeq   uint64
+	restored bool
+	lastDump atomic.Pointer[dumpStatus]
+}
+
+type dumpStatus struct {
+	at  time.Time
+	err error
 }
 
 func NewFileStore(path string, interval int) *FileStorage {
@@ -26,75 +62,368 @@ func NewFileStore(path string, interval int) *FileStorage {
 		MemStorage: *NewMemStore(),
 		FilePath:   path,
 		interval:   interval,
+		walMtx:     &sync.Mutex{},
 	}
 }
 
 func (fs *FileStorage) Put(cx ctx.Context, met *s.Metrics) (*s.Metrics, error) {
-	m, _ := fs.MemStorage.Put(cx, met)
-	if fs.interval <= 0 {
-		if err := fs.dump(cx); err != nil {
-			return m, err
-		}
+	var m *s.Metrics
+	err := fs.logged(cx, []*s.Metrics{met}, func() {
+		m, _ = fs.MemStorage.Put(cx, met)
+	})
+	if err != nil {
+		return nil, err
 	}
 	return m, nil
 }
 
 func (fs *FileStorage) PutBatch(cx ctx.Context, mets []*s.Metrics) error {
-	_ = fs.MemStorage.PutBatch(cx, mets)
-	if fs.interval <= 0 {
-		if err := fs.dump(cx); err != nil {
+	return fs.logged(cx, mets, func() {
+		_ = fs.MemStorage.PutBatch(cx, mets)
+	})
+}
+
+// logged пишет обновление в WAL и применяет его к памяти под одной
+// блокировкой, чтобы порядок в журнале совпадал с порядком применения.
+func (fs *FileStorage) logged(cx ctx.Context, mets []*s.Metrics, apply func()) error {
+	fs.walMtx.Lock()
+	defer fs.walMtx.Unlock()
+	if err := fs.appendWAL(cx, mets); err != nil {
+		return err
+	}
+	apply()
+	if fs.walSize >= walCompactAt {
+		if err := fs.snapshot(cx); err != nil {
+			log.Ctx(cx).Warn("WAL compaction error", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (fs *FileStorage) appendWAL(cx ctx.Context, mets []*s.Metrics) error {
+	if fs.wal == nil {
+		if !fs.restored {
+			// без восстановления номера продолжают номер старого снимка,
+			// иначе после сбоя новые записи были бы пропущены
+			if snap, err := readSnapshot(cx, fs.FilePath); err == nil {
+				fs.walSeq = snap.WALSeq
+			}
+		}
+		wal, err := openWAL(fs.walPath(), !fs.restored)
+		if err != nil {
 			return err
 		}
+		info, err := wal.Stat()
+		if err != nil {
+			wal.Close()
+			return fmt.Errorf("wal stat: %w", err)
+		}
+		fs.wal = wal
+		fs.walSize = info.Size()
+	}
+	rec, err := encodeWALRecord(fs.walSeq+1, mets)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.wal.Write(rec); err != nil {
+		return fmt.Errorf("wal append: %w", err)
+	}
+	fs.walSeq++
+	fs.walSize += int64(len(rec))
+	if fs.interval <= 0 { // синхронный режим: каждая запись сразу на диске
+		if err := fs.wal.Sync(); err != nil {
+			return fmt.Errorf("wal sync: %w", err)
+		}
 	}
 	return nil
 }
 
+func (fs *FileStorage) walPath() string {
+	return fs.FilePath + walSuffix
+}
+
 func (fs *FileStorage) Close() {
+	fs.walMtx.Lock()
+	if fs.wal != nil {
+		if err := fs.wal.Close(); err != nil {
+			log.Warn("WAL close error", zap.Error(err))
+		}
+		fs.wal = nil
+	}
+	fs.walMtx.Unlock()
 	log.Info("File storage is closed;)")
 }
 
 func (fs *FileStorage) RestoreFromFile(cx ctx.Context) {
-	b, err := os.ReadFile(fs.FilePath)
+	fs.walMtx.Lock()
+	defer fs.walMtx.Unlock()
+	fs.restoreSnapshot(cx)
+	fs.replayWAL(cx)
+	fs.restored = true
+}
+
+// restoreSnapshot загружает основной снимок, а если он не читается -
+// самую свежую целую резервную копию.
+func (fs *FileStorage) restoreSnapshot(cx ctx.Context) {
+	for i := 0; i <= fs.Backups; i++ {
+		path := fs.backupPath(i)
+		snap, err := readSnapshot(cx, path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Warn("RestoreFromFile: bad snapshot", zap.String("path", path), zap.Error(err))
+			continue
+		}
+		if i > 0 {
+			log.Warn("RestoreFromFile: restored from backup, recent data may be lost",
+				zap.String("path", path))
+		}
+		for _, m := range snap.Metrics {
+			_, _ = fs.MemStorage.Put(cx, m)
+		}
+		fs.walSeq = snap.WALSeq
+		log.Debug("success restore from file!", zap.String("path", path))
+		return
+	}
+	log.Warn("RestoreFromFile: no valid snapshot found", zap.String("path", fs.FilePath))
+}
+
+func readSnapshot(cx ctx.Context, path string) (*snapshotFile, error) {
+	b, err := os.ReadFile(path)
 	if err != nil && !os.IsPermission(err) && !os.IsNotExist(err) {
-		if err := s.Retry(cx, func() error {
-			b, err = os.ReadFile(fs.FilePath)
+		err = s.Retry(cx, func() error {
+			b, err = os.ReadFile(path)
 			return err
-		}); err != nil {
-			log.Warn("RestoreFromFile: err after retry", zap.Error(err))
-			return
-		}
+		})
 	}
 	if err != nil {
-		log.Warn("RestoreFromFile", zap.Error(err))
+		return nil, err
+	}
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '[' {
+		var mets []*s.Metrics
+		if err := ffjson.Unmarshal(b, &mets); err != nil {
+			return nil, fmt.Errorf("unmarshal legacy snapshot: %w", err)
+		}
+		log.Info("RestoreFromFile: migrating legacy snapshot", zap.String("path", path))
+		return &snapshotFile{Version: snapshotVersion, Metrics: migrateLegacySnapshot(mets)}, nil
+	}
+	var snap snapshotFile
+	if err := ffjson.Unmarshal(b, &snap); err != nil {
+		return nil, fmt.Errorf("unmarshal snapshot: %w", err)
+	}
+	if snap.Version != snapshotVersion {
+		return nil, fmt.Errorf("%w: %d", ErrSnapshotVersion, snap.Version)
+	}
+	return &snap, nil
+}
+
+// migrateLegacySnapshot чистит записи v1: прежний MemStorage при смене типа
+// метрики сливал gauge со старым счетчиком, и у gauge оставался delta.
+func migrateLegacySnapshot(mets []*s.Metrics) []*s.Metrics {
+	res := make([]*s.Metrics, 0, len(mets))
+	for _, m := range mets {
+		switch {
+		case m == nil:
+			continue
+		case m.IsCounter() && m.Delta != nil:
+			m.Value = nil
+		case m.IsGauge() && m.Value != nil:
+			m.Delta = nil
+		default:
+			log.Warn("RestoreFromFile: dropping invalid legacy metric", zap.String("id", m.ID))
+			continue
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+func (fs *FileStorage) backupPath(n int) string {
+	if n == 0 {
+		return fs.FilePath
+	}
+	return fs.FilePath + "." + strconv.Itoa(n)
+}
+
+func (fs *FileStorage) replayWAL(cx ctx.Context) {
+	f, err := os.Open(fs.walPath())
+	if os.IsNotExist(err) {
 		return
 	}
-	var mets []*s.Metrics
-	if err := ffjson.Unmarshal(b, &mets); err != nil {
-		log.Warn("RestoreFromFile: unmarshall error", zap.Error(err))
+	if err != nil {
+		log.Warn("RestoreFromFile: open wal", zap.Error(err))
 		return
 	}
-	for _, m := range mets {
-		_, _ = fs.MemStorage.Put(cx, m)
+	defer f.Close()
+
+	wr := newWALReader(f)
+	covered := fs.walSeq
+	records, skipped := 0, 0
+	for {
+		rec, err := wr.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// недописанный хвост отбрасываем, чтобы новые записи шли за
+			// последней целой записью
+			log.Warn("RestoreFromFile: skipping corrupted WAL tail",
+				zap.Int64("offset", wr.offset), zap.Error(err))
+			if err := os.Truncate(fs.walPath(), wr.offset); err != nil {
+				log.Warn("RestoreFromFile: truncate wal", zap.Error(err))
+			}
+			break
+		}
+		// запись уже в снимке: сбой между записью снимка и очисткой WAL
+		if rec.Seq != 0 && rec.Seq <= covered {
+			skipped++
+			continue
+		}
+		_ = fs.MemStorage.PutBatch(cx, rec.Metrics)
+		fs.walSeq = max(fs.walSeq, rec.Seq)
+		records++
 	}
-	log.Debug("success restore from file!")
+	log.Debug("WAL replayed", zap.Int("records", records), zap.Int("skipped", skipped))
+}
+
+// reset очищает память и записывает пустой снимок вместо журнала.
+func (fs *FileStorage) reset(cx ctx.Context) error {
+	fs.walMtx.Lock()
+	defer fs.walMtx.Unlock()
+	_ = fs.MemStorage.reset(cx)
+	return fs.snapshot(cx)
 }
 
 func (fs *FileStorage) dump(cx ctx.Context) error {
+	fs.walMtx.Lock()
+	defer fs.walMtx.Unlock()
+	return fs.snapshot(cx)
+}
+
+// snapshot записывает полный снимок и очищает WAL. Вызывается под walMtx.
+func (fs *FileStorage) snapshot(cx ctx.Context) error {
+	start := time.Now()
+	err := fs.writeDump(cx)
+	fs.lastDump.Store(&dumpStatus{at: start, err: err})
+	selfstat.Inc(selfstat.Name("file", "dumps"))
+	selfstat.Set(selfstat.Name("file", "dump_ms"), float64(time.Since(start).Microseconds())/1000)
+	return err
+}
+
+func (fs *FileStorage) writeDump(cx ctx.Context) error {
 	items, _ := fs.List(cx)
-	metBytes, err := ffjson.Marshal(items)
+	metBytes, err := ffjson.Marshal(snapshotFile{
+		Version: snapshotVersion,
+		WALSeq:  fs.walSeq,
+		Metrics: items,
+	})
 	if err != nil {
 		return fmt.Errorf("dump: %w", err)
 	}
-	err = os.WriteFile(fs.FilePath, metBytes, permissions)
-	if err != nil && !os.IsPermission(err) {
+	err = fs.writeSnapshot(metBytes)
+	if err != nil && !errors.Is(err, os.ErrPermission) {
 		err = s.Retry(cx, func() error {
-			return os.WriteFile(fs.FilePath, metBytes, permissions)
+			return fs.writeSnapshot(metBytes)
 		})
 	}
 	if err != nil {
 		return fmt.Errorf("dump: %w", err)
 	}
-	log.Debug("success dump!")
+	if fs.wal != nil {
+		if err := fs.wal.Truncate(0); err != nil {
+			return fmt.Errorf("dump: truncate wal: %w", err)
+		}
+		if err := fs.wal.Sync(); err != nil {
+			return fmt.Errorf("dump: sync wal: %w", err)
+		}
+	} else if err := os.Remove(fs.walPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("dump: remove wal: %w", err)
+	}
+	fs.walSize = 0
+	fs.restored = true
+	log.Ctx(cx).Debug("success dump!")
+	return nil
+}
+
+// writeSnapshot пишет снимок во временный файл и атомарно подменяет им
+// основной, предварительно сдвинув резервные копии.
+func (fs *FileStorage) writeSnapshot(data []byte) error {
+	dir := filepath.Dir(fs.FilePath)
+	tmp, err := os.CreateTemp(dir, filepath.Base(fs.FilePath)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temp snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write temp snapshot: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), permissions); err != nil {
+		return fmt.Errorf("chmod temp snapshot: %w", err)
+	}
+	if err := fs.rotateBackups(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), fs.FilePath); err != nil {
+		return fmt.Errorf("rename snapshot: %w", err)
+	}
+	return syncDir(dir)
+}
+
+func (fs *FileStorage) rotateBackups() error {
+	if fs.Backups <= 0 {
+		return nil
+	}
+	for i := fs.Backups; i > 1; i-- {
+		err := os.Rename(fs.backupPath(i-1), fs.backupPath(i))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("rotate backups: %w", err)
+		}
+	}
+	// при Backups == 1 цикл ничего не сдвигает: старая копия удаляется
+	err := os.Remove(fs.backupPath(1))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("rotate backups: %w", err)
+	}
+	// основной снимок остается на месте до атомарной подмены
+	err = os.Link(fs.FilePath, fs.backupPath(1))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("rotate backups: %w", err)
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open dir: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync dir: %w", err)
+	}
+	return nil
+}
+
+// dumpCheck для /readyz: последний снимок должен быть успешным, а при
+// периодическом сохранении еще и не старше трех интервалов.
+func (fs *FileStorage) dumpCheck(ctx.Context) error {
+	st := fs.lastDump.Load()
+	if st == nil {
+		return nil // снимков еще не было
+	}
+	if st.err != nil {
+		return fmt.Errorf("%w at %s: %w", ErrDumpFailed, st.at.Format(time.RFC3339), st.err)
+	}
+	if fs.interval > 0 && time.Since(st.at) > 3*time.Duration(fs.interval)*time.Second {
+		return fmt.Errorf("%w: no dump since %s", ErrDumpFailed, st.at.Format(time.RFC3339))
+	}
 	return nil
 }
 
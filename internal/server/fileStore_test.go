@@ -0,0 +1,182 @@
+package server
+
+import (
+	ctx "context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	s "metrics/internal/service"
+)
+
+func TestFileStoreWALReplay(t *testing.T) {
+	cx := ctx.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	fs := NewFileStore(path, 0)
+	fs.RestoreFromFile(cx)
+	_, _ = fs.Put(cx, s.BuildMetric("PollCount", int64(2)))
+	_ = fs.dump(cx)
+	_, _ = fs.Put(cx, s.BuildMetric("PollCount", int64(3)))
+	_ = fs.PutBatch(cx, []*s.Metrics{
+		s.BuildMetric("Alloc", 1.5),
+		s.BuildMetric("PollCount", int64(5)),
+	})
+	fs.Close()
+
+	// недописанная запись в конце журнала
+	wal, err := os.OpenFile(path+walSuffix, os.O_WRONLY|os.O_APPEND, permissions)
+	if err != nil {
+		t.Fatalf("open wal: %v", err)
+	}
+	_, _ = wal.Write([]byte{0, 0, 0, 42, 1, 2, 3, 4, '[', '{'})
+	wal.Close()
+
+	restored := NewFileStore(path, 0)
+	restored.RestoreFromFile(cx)
+	counter, err := restored.Get(cx, &s.Metrics{ID: "PollCount", MType: s.Counter})
+	if err != nil || *counter.Delta != 10 {
+		t.Fatalf("expected PollCount=10 after replay, got %v (%v)", counter, err)
+	}
+	gauge, err := restored.Get(cx, &s.Metrics{ID: "Alloc", MType: s.Gauge})
+	if err != nil || *gauge.Value != 1.5 {
+		t.Fatalf("expected Alloc=1.5 after replay, got %v (%v)", gauge, err)
+	}
+
+	// хвост обрезан, новые записи читаются после перезапуска
+	_, _ = restored.Put(cx, s.BuildMetric("PollCount", int64(1)))
+	restored.Close()
+	again := NewFileStore(path, 0)
+	again.RestoreFromFile(cx)
+	counter, _ = again.Get(cx, &s.Metrics{ID: "PollCount", MType: s.Counter})
+	if counter == nil || *counter.Delta != 11 {
+		t.Fatalf("expected PollCount=11 after second restart, got %v", counter)
+	}
+}
+
+func TestFileStoreBackupFallback(t *testing.T) {
+	cx := ctx.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	fs := NewFileStore(path, 0)
+	fs.Backups = 2
+	for i := 1; i <= 3; i++ {
+		_, _ = fs.Put(cx, s.BuildMetric("Alloc", float64(i)))
+		if err := fs.dump(cx); err != nil {
+			t.Fatalf("dump: %v", err)
+		}
+	}
+	fs.Close()
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("expected only 2 backups, stat .3: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(`[{"id":"Al`), permissions); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewFileStore(path, 0)
+	restored.Backups = 2
+	restored.RestoreFromFile(cx)
+	gauge, err := restored.Get(cx, &s.Metrics{ID: "Alloc", MType: s.Gauge})
+	if err != nil || *gauge.Value != 2 {
+		t.Fatalf("expected Alloc=2 from newest backup, got %v (%v)", gauge, err)
+	}
+}
+
+func TestFileStoreLegacySnapshot(t *testing.T) {
+	cx := ctx.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	// v1: gauge затер одноименный счетчик и унаследовал его delta
+	legacy := `[{"id":"Mixed","type":"gauge","value":2.5,"delta":7},{"id":"PollCount","type":"counter","delta":3}]`
+	if err := os.WriteFile(path, []byte(legacy), permissions); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := NewFileStore(path, 0)
+	fs.RestoreFromFile(cx)
+	if _, err := fs.Get(cx, &s.Metrics{ID: "Mixed", MType: s.Counter}); err == nil {
+		t.Fatal("legacy gauge must not be visible as a counter")
+	}
+	_, _ = fs.Put(cx, s.BuildMetric("Mixed", int64(1)))
+	gauge, _ := fs.Get(cx, &s.Metrics{ID: "Mixed", MType: s.Gauge})
+	counter, _ := fs.Get(cx, &s.Metrics{ID: "Mixed", MType: s.Counter})
+	if gauge == nil || gauge.Delta != nil || *gauge.Value != 2.5 {
+		t.Fatalf("unexpected gauge after migration: %+v", gauge)
+	}
+	if counter == nil || *counter.Delta != 1 {
+		t.Fatalf("unexpected counter: %+v", counter)
+	}
+	if err := fs.dump(cx); err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+
+	restored := NewFileStore(path, 0)
+	restored.RestoreFromFile(cx)
+	list, _ := restored.List(cx)
+	if len(list) != 3 {
+		t.Fatalf("expected 3 metrics in v2 snapshot, got %d", len(list))
+	}
+}
+
+func TestFileStoreCrashBeforeWALTruncate(t *testing.T) {
+	cx := ctx.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	fs := NewFileStore(path, 0)
+	fs.RestoreFromFile(cx)
+	_, _ = fs.Put(cx, s.BuildMetric("PollCount", int64(5)))
+	wal, err := os.ReadFile(path + walSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.dump(cx); err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+	// сбой после подмены снимка, но до очистки журнала
+	if err := os.WriteFile(path+walSuffix, wal, permissions); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewFileStore(path, 0)
+	restored.RestoreFromFile(cx)
+	counter, _ := restored.Get(cx, &s.Metrics{ID: "PollCount", MType: s.Counter})
+	if counter == nil || *counter.Delta != 5 {
+		t.Fatalf("expected PollCount=5, WAL must not be replayed twice, got %v", counter)
+	}
+	// новые записи идут после уже учтенных и не пропускаются
+	_, _ = restored.Put(cx, s.BuildMetric("PollCount", int64(1)))
+	info, _ := os.Stat(path + walSuffix)
+	if restored.walSize != info.Size() {
+		t.Errorf("walSize %d, file size %d", restored.walSize, info.Size())
+	}
+	restored.Close()
+
+	again := NewFileStore(path, 0)
+	again.RestoreFromFile(cx)
+	counter, _ = again.Get(cx, &s.Metrics{ID: "PollCount", MType: s.Counter})
+	if counter == nil || *counter.Delta != 6 {
+		t.Fatalf("expected PollCount=6 after second restart, got %v", counter)
+	}
+}
+
+func TestFileStoreSingleBackup(t *testing.T) {
+	cx := ctx.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	fs := NewFileStore(path, 0)
+	fs.Backups = 1
+	for i := 1; i <= 4; i++ {
+		_, _ = fs.Put(cx, s.BuildMetric("Alloc", float64(i)))
+		if err := fs.dump(cx); err != nil {
+			t.Fatalf("dump %d: %v", i, err)
+		}
+	}
+	fs.Close()
+
+	snap, err := readSnapshot(cx, path+".1")
+	if err != nil || len(snap.Metrics) != 1 || *snap.Metrics[0].Value != 3 {
+		t.Fatalf("expected previous snapshot (Alloc=3) in backup, got %+v (%v)", snap, err)
+	}
+}
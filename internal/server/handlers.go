@@ -2,13 +2,20 @@ package server
 
 import (
 	ctx "context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
-	"strconv"
+	"strings"
+	"time"
 
+	"metrics/internal/alert"
+	"metrics/internal/derive"
 	log "metrics/internal/logger"
+	"metrics/internal/otlp"
 	s "metrics/internal/service"
+	"metrics/internal/tracing"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/pquerna/ffjson/ffjson"
@@ -21,6 +28,8 @@ const (
 	value = "value"
 )
 
+const streamHeartbeat = 15 * time.Second
+
 type Storage interface {
 	Put(ctx.Context, *s.Metrics) (*s.Metrics, error)
 	Get(ctx.Context, *s.Metrics) (*s.Metrics, error)
@@ -32,6 +41,70 @@ type Storage interface {
 type MetricManager struct {
 	Storage
 	http.Server
+	Alerts        *alert.Engine
+	Notifier      *alert.Notifier
+	Derived       *derive.Set
+	updates       *updateTracker
+	broker        *broker
+	otlp          *otlp.Converter
+	liveness      []Check
+	readiness     []Check
+	RulesInterval time.Duration
+}
+
+func NewMetricManager() *MetricManager {
+	return &MetricManager{
+		Server:  http.Server{},
+		updates: newUpdateTracker(),
+		broker:  newBroker(),
+		otlp:    otlp.NewConverter(),
+	}
+}
+
+func (mm *MetricManager) Put(cx ctx.Context, met *s.Metrics) (*s.Metrics, error) {
+	if err := checkReserved(met); err != nil {
+		return nil, err
+	}
+	scx, done := mm.observeStorage(cx, "put")
+	met, err := mm.Storage.Put(scx, met)
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+	mm.updates.touch(met)
+	mm.broker.publish(met)
+	mm.derive(cx, []*s.Metrics{met})
+	return met, nil
+}
+
+func (mm *MetricManager) PutBatch(cx ctx.Context, mets []*s.Metrics) error {
+	if err := checkReserved(mets...); err != nil {
+		return err
+	}
+	scx, done := mm.observeStorage(cx, "put_batch")
+	err := mm.Storage.PutBatch(scx, mets)
+	done(err)
+	if err != nil {
+		return err
+	}
+	mm.updates.touch(mets...)
+	mm.broker.publish(mets...)
+	mm.derive(cx, mets)
+	return nil
+}
+
+func (mm *MetricManager) Get(cx ctx.Context, met *s.Metrics) (*s.Metrics, error) {
+	scx, done := mm.observeStorage(cx, "get")
+	met, err := mm.Storage.Get(scx, met)
+	done(err)
+	return met, err
+}
+
+func (mm *MetricManager) List(cx ctx.Context) ([]*s.Metrics, error) {
+	scx, done := mm.observeStorage(cx, "list")
+	mets, err := mm.Storage.List(scx)
+	done(err)
+	return mets, err
 }
 
 func (mm *MetricManager) Run(cx ctx.Context) {
@@ -44,6 +117,15 @@ func (mm *MetricManager) Run(cx ctx.Context) {
 		close(errChan)
 	}()
 
+	if mm.Alerts != nil {
+		go mm.evalRules(cx)
+	}
+	go mm.flushSelfStats(cx)
+	if db, ok := mm.Storage.(*DataBase); ok {
+		go db.runMaintenance(cx)
+		go db.runFlusher(cx)
+	}
+
 	dumpWaitDone := make(chan struct{})
 	fileStore, isFileStore := mm.Storage.(*FileStorage)
 	if isFileStore {
@@ -59,6 +141,7 @@ func (mm *MetricManager) Run(cx ctx.Context) {
 		}
 		_ = mm.Shutdown(cx)
 		mm.Storage.Close()
+		tracing.Shutdown()
 		log.Debug("Goodbye!")
 	case err := <-errChan:
 		log.Fatal("server running error", zap.Error(err))
@@ -71,12 +154,16 @@ func (mm *MetricManager) UpdateHandler(rw http.ResponseWriter, req *http.Request
 		chi.URLParam(req, id),
 		chi.URLParam(req, value))
 	if err != nil {
-		log.Warn("NewMetric error", zap.Error(err))
+		log.Ctx(req.Context()).Warn("NewMetric error", zap.Error(err))
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if _, err = mm.Put(req.Context(), metric); err != nil {
-		log.Warn("UpdateHandler(): storage error", zap.Error(err))
+	if _, err = mm.Put(req.Context(), metric); errors.Is(err, ErrReservedID) {
+		log.Ctx(req.Context()).Warn("UpdateHandler(): reserved id", zap.Error(err))
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Ctx(req.Context()).Warn("UpdateHandler(): storage error", zap.Error(err))
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -89,44 +176,49 @@ func (mm *MetricManager) GetHandler(rw http.ResponseWriter, req *http.Request) {
 		chi.URLParam(req, id),
 		"")
 	if errors.Is(s.ErrInvalidType, err) {
-		log.Warn("GetHandler()", zap.Error(err))
+		log.Ctx(req.Context()).Warn("GetHandler()", zap.Error(err))
 		http.Error(rw, err.Error(), http.StatusNotFound)
 		return
 	}
 	metric, err := mm.Get(req.Context(), met)
+	if err == nil && metric.MType != met.MType {
+		err = ErrNoValue
+	}
 	if errors.Is(err, ErrConnDB) {
-		log.Warn("GetHandler(): storage error", zap.Error(err))
+		log.Ctx(req.Context()).Warn("GetHandler(): storage error", zap.Error(err))
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	} else if err != nil {
-		log.Warn("GetHandler(): Coundn't fetch the metric from store", zap.Error(err))
+		log.Ctx(req.Context()).Warn("GetHandler(): Coundn't fetch the metric from store", zap.Error(err))
 		http.Error(rw, err.Error(), http.StatusNotFound)
 		return
 	}
-	var numStr string
-	if metric.IsCounter() {
-		numStr = strconv.FormatInt(*metric.Delta, 10)
-	} else {
-		numStr = strconv.FormatFloat(*metric.Value, 'f', -1, 64)
-	}
 	rw.WriteHeader(http.StatusOK)
-	_, _ = rw.Write([]byte(numStr))
+	_, _ = rw.Write([]byte(formatValue(metric)))
 }
 
 func (mm *MetricManager) GetAllHandler(rw http.ResponseWriter, req *http.Request) {
-	list := make([]Item, 0, metricsNumber)
 	metrics, err := mm.List(req.Context())
 	if errors.Is(err, ErrConnDB) {
-		log.Warn("GetAllHandler(): storage error", zap.Error(err))
+		log.Ctx(req.Context()).Warn("GetAllHandler(): storage error", zap.Error(err))
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	for _, m := range metrics {
-		list = append(list, Item{Met: m.String()})
+	items := mm.buildItems(metrics)
+	if strings.Contains(req.Header.Get("Accept"), "application/json") {
+		data, err := json.Marshal(items)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write(data)
+		return
 	}
-	html, err := renderGetAll(list)
+	html, err := renderGetAll(items)
 	if err != nil {
-		log.Warn("GetAllHandler(): An error occured during html rendering")
+		log.Ctx(req.Context()).Warn("GetAllHandler(): An error occured during html rendering")
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -135,18 +227,64 @@ func (mm *MetricManager) GetAllHandler(rw http.ResponseWriter, req *http.Request
 	_, _ = rw.Write(html.Bytes())
 }
 
+func (mm *MetricManager) StreamHandler(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	mt := query.Get(mtype)
+	if mt != "" && mt != s.Gauge && mt != s.Counter {
+		http.Error(rw, s.ErrInvalidType.Error(), http.StatusBadRequest)
+		return
+	}
+	sub := mm.broker.subscribe(query.Get("prefix"), mt)
+	defer mm.broker.unsubscribe(sub)
+
+	rc := http.NewResponseController(rw)
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		log.Ctx(req.Context()).Warn("StreamHandler(): flush error", zap.Error(err))
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case data := <-sub.ch:
+			_, err = fmt.Fprintf(rw, "event: metric\ndata: %s\n\n", data)
+		case <-heartbeat.C:
+			_, err = fmt.Fprintf(rw, "event: heartbeat\ndata: {\"dropped\":%d}\n\n",
+				sub.dropped.Load())
+		case <-req.Context().Done():
+			return
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			log.Ctx(req.Context()).Debug("StreamHandler(): client is gone", zap.Error(err))
+			return
+		}
+	}
+}
+
 func (mm *MetricManager) UpdateJSON(rw http.ResponseWriter, req *http.Request) {
-	log.Debug("UpdateJSON...")
+	log.Ctx(req.Context()).Debug("UpdateJSON...")
 	bytes, err := io.ReadAll(req.Body)
 	if err != nil {
-		log.Warn("Couldn't read with decompress")
+		log.Ctx(req.Context()).Warn("Couldn't read with decompress")
 	}
 	defer req.Body.Close()
 
 	metric := &s.Metrics{}
 	_ = metric.UnmarshalJSON(bytes)
-	if metric, err = mm.Put(req.Context(), metric); err != nil {
-		log.Warn("UpdateJSON(): couldn't write to store", zap.Error(err))
+	if metric, err = mm.Put(req.Context(), metric); errors.Is(err, ErrReservedID) {
+		log.Ctx(req.Context()).Warn("UpdateJSON(): reserved id", zap.Error(err))
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Ctx(req.Context()).Warn("UpdateJSON(): couldn't write to store", zap.Error(err))
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -156,23 +294,27 @@ func (mm *MetricManager) UpdateJSON(rw http.ResponseWriter, req *http.Request) {
 }
 
 func (mm *MetricManager) GetJSON(rw http.ResponseWriter, req *http.Request) {
-	log.Debug("GetJSON...")
+	log.Ctx(req.Context()).Debug("GetJSON...")
 	bytes, err := io.ReadAll(req.Body)
 	if err != nil {
-		log.Warn("GetJSON(): Couldn't read request body")
+		log.Ctx(req.Context()).Warn("GetJSON(): Couldn't read request body")
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
 	defer req.Body.Close()
 
-	metric := &s.Metrics{}
-	_ = metric.UnmarshalJSON(bytes)
-	if metric, err = mm.Get(req.Context(), metric); errors.Is(err, ErrConnDB) {
-		log.Warn("GetJSON(): store error", zap.Error(err))
+	wanted := &s.Metrics{}
+	_ = wanted.UnmarshalJSON(bytes)
+	metric, err := mm.Get(req.Context(), wanted)
+	if err == nil && metric.MType != wanted.MType {
+		err = ErrNoValue
+	}
+	if errors.Is(err, ErrConnDB) {
+		log.Ctx(req.Context()).Warn("GetJSON(): store error", zap.Error(err))
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	} else if err != nil {
-		log.Warn("GetJSON(): No such metric in store", zap.Error(err))
+		log.Ctx(req.Context()).Warn("GetJSON(): No such metric in store", zap.Error(err))
 		http.Error(rw, err.Error(), http.StatusNotFound)
 		return
 	}
@@ -184,8 +326,14 @@ func (mm *MetricManager) GetJSON(rw http.ResponseWriter, req *http.Request) {
 
 func (mm *MetricManager) PingHandler(rw http.ResponseWriter, req *http.Request) {
 	if db, ok := mm.Storage.(*DataBase); ok {
-		if err := db.Ping(req.Context()); err != nil {
-			log.Warn("ping error", zap.Error(err))
+		err := db.Check(req.Context())
+		rw.Header().Set("X-Circuit-State", db.CircuitState())
+		if errors.Is(err, ErrCircuitOpen) {
+			log.Ctx(req.Context()).Warn("ping: circuit is open", zap.Error(err))
+			http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			log.Ctx(req.Context()).Warn("ping error", zap.Error(err))
 			http.Error(rw, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -195,10 +343,10 @@ func (mm *MetricManager) PingHandler(rw http.ResponseWriter, req *http.Request)
 }
 
 func (mm *MetricManager) BatchHandler(rw http.ResponseWriter, req *http.Request) {
-	log.Debug("BatchHandler...")
+	log.Ctx(req.Context()).Debug("BatchHandler...")
 	b, err := io.ReadAll(req.Body)
 	if err != nil {
-		log.Warn("BatchHandler(): Couldn't read request body")
+		log.Ctx(req.Context()).Warn("BatchHandler(): Couldn't read request body")
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -206,12 +354,19 @@ func (mm *MetricManager) BatchHandler(rw http.ResponseWriter, req *http.Request)
 
 	var metrics []*s.Metrics
 	if err = ffjson.Unmarshal(b, &metrics); err != nil {
-		log.Warn("batchHandler(): unmarshal error", zap.Error(err))
+		log.Ctx(req.Context()).Warn("batchHandler(): unmarshal error", zap.Error(err))
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	for _, met := range metrics {
+		if met == nil {
+			log.Ctx(req.Context()).Warn("batchHandler(): null metric in batch")
+			http.Error(rw, s.ErrInvalidVal.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 	if err = mm.PutBatch(req.Context(), metrics); err != nil {
-		log.Warn("UpdatesJSON(): couldn't send the batch", zap.Error(err))
+		log.Ctx(req.Context()).Warn("UpdatesJSON(): couldn't send the batch", zap.Error(err))
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
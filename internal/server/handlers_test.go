@@ -1,9 +1,15 @@
 package server
 
 import (
+	ctx "context"
+	"encoding/json"
 	"log"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	s "metrics/internal/service"
 )
 
 func TestHandlers(t *testing.T) {
@@ -62,3 +68,44 @@ func TestHandlers(t *testing.T) {
 		log.Println("\n\nTEST NAME:", test.name)
 	}
 }
+
+func TestBatchHandlerRejects(t *testing.T) {
+	mm := NewMetricManager()
+	mm.Storage = NewMemStore()
+	for body, want := range map[string]int{
+		`[{"id":"a","type":"gauge","value":1},null]`:     http.StatusBadRequest,
+		`[{"id":"__server.x","type":"gauge","value":1}]`: http.StatusBadRequest,
+		`[{"id":"a","type":"counter","delta":2}]`:        http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		mm.BatchHandler(rec, httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body)))
+		if rec.Code != want {
+			t.Errorf("%s: status %d, want %d", body, rec.Code, want)
+		}
+	}
+}
+
+func TestGetAllHandlerJSON(t *testing.T) {
+	mm := NewMetricManager()
+	mm.Storage = NewMemStore()
+	delta, val := int64(3), 1.5
+	_ = mm.PutBatch(ctx.Background(), []*s.Metrics{
+		{ID: "b", MType: s.Gauge, Value: &val},
+		{ID: "a", MType: s.Counter, Delta: &delta},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	mm.GetAllHandler(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var items []Item
+	if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].ID != "a" || items[0].Value != "3" || items[1].Value != "1.5" {
+		t.Fatalf("items = %+v", items)
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "metrics/internal/logger"
+
+	"github.com/shirou/gopsutil/v4/disk"
+	"go.uber.org/zap"
+)
+
+const (
+	checkTimeout = 2 * time.Second
+	minFreeDisk  = 64 << 20
+)
+
+var (
+	ErrLowDisk    = errors.New("low disk space")
+	ErrDumpFailed = errors.New("last dump failed")
+)
+
+// Check - проверка зависимости для /healthz или /readyz.
+type Check struct {
+	Name string
+	Fn   func(ctx.Context) error
+}
+
+type checkResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+type healthResponse struct {
+	Status string        `json:"status"`
+	Checks []checkResult `json:"checks"`
+}
+
+// AddLivenessCheck добавляет проверку в /healthz. Провал liveness означает,
+// что процесс нужно перезапустить, поэтому внешние зависимости сюда не идут.
+func (mm *MetricManager) AddLivenessCheck(name string, fn func(ctx.Context) error) {
+	mm.liveness = append(mm.liveness, Check{Name: name, Fn: fn})
+}
+
+// AddReadinessCheck добавляет проверку в /readyz.
+func (mm *MetricManager) AddReadinessCheck(name string, fn func(ctx.Context) error) {
+	mm.readiness = append(mm.readiness, Check{Name: name, Fn: fn})
+}
+
+// StorageChecks возвращает проверки готовности для хранилища.
+func StorageChecks(st Storage) []Check {
+	switch st := st.(type) {
+	case *DataBase:
+		return []Check{{Name: "db", Fn: st.Check}}
+	case *SQLiteStorage:
+		return []Check{{Name: "db", Fn: st.db.PingContext}}
+	case *KVStorage:
+		return []Check{{Name: "disk", Fn: diskCheck(filepath.Dir(st.db.Path()))}}
+	case *FileStorage:
+		dir := filepath.Dir(st.FilePath)
+		return []Check{
+			{Name: "file_writable", Fn: writableCheck(dir)},
+			{Name: "last_dump", Fn: st.dumpCheck},
+			{Name: "disk", Fn: diskCheck(dir)},
+		}
+	}
+	return nil
+}
+
+func writableCheck(dir string) func(ctx.Context) error {
+	return func(ctx.Context) error {
+		f, err := os.CreateTemp(dir, ".healthcheck-*")
+		if err != nil {
+			return fmt.Errorf("not writable: %w", err)
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	}
+}
+
+func diskCheck(dir string) func(ctx.Context) error {
+	return func(cx ctx.Context) error {
+		usage, err := disk.UsageWithContext(cx, dir)
+		if err != nil {
+			return fmt.Errorf("disk usage: %w", err)
+		}
+		if usage.Free < minFreeDisk {
+			return fmt.Errorf("%w: %d bytes free in %s", ErrLowDisk, usage.Free, dir)
+		}
+		return nil
+	}
+}
+
+func runChecks(cx ctx.Context, checks []Check) (healthResponse, bool) {
+	resp := healthResponse{Status: "ok", Checks: make([]checkResult, len(checks))}
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c Check) {
+			defer wg.Done()
+			ccx, cancel := ctx.WithTimeout(cx, checkTimeout)
+			defer cancel()
+			start := time.Now()
+			err := c.Fn(ccx)
+			res := checkResult{
+				Name:      c.Name,
+				Status:    "ok",
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				res.Status = "fail"
+				res.Error = err.Error()
+			}
+			resp.Checks[i] = res
+		}(i, c)
+	}
+	wg.Wait()
+	healthy := true
+	for _, res := range resp.Checks {
+		if res.Status != "ok" {
+			healthy = false
+			resp.Status = "fail"
+		}
+	}
+	return resp, healthy
+}
+
+func writeHealth(rw http.ResponseWriter, req *http.Request, checks []Check) {
+	resp, healthy := runChecks(req.Context(), checks)
+	status := http.StatusOK
+	if !healthy {
+		log.Ctx(req.Context()).Warn("health check failed", zap.String("path", req.URL.Path), zap.Any("checks", resp.Checks))
+		status = http.StatusServiceUnavailable
+	}
+	data, _ := json.Marshal(resp)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_, _ = rw.Write(data)
+}
+
+func (mm *MetricManager) HealthzHandler(rw http.ResponseWriter, req *http.Request) {
+	writeHealth(rw, req, mm.liveness)
+}
+
+func (mm *MetricManager) ReadyzHandler(rw http.ResponseWriter, req *http.Request) {
+	writeHealth(rw, req, mm.readiness)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadyzFileStorage(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewFileStore(filepath.Join(dir, "metrics.json"), 0)
+	mm := NewMetricManager()
+	mm.Storage = fs
+	for _, c := range StorageChecks(fs) {
+		mm.AddReadinessCheck(c.Name, c.Fn)
+	}
+	mm.AddLivenessCheck("custom", func(ctx.Context) error { return nil })
+
+	ready := func() (int, healthResponse) {
+		rec := httptest.NewRecorder()
+		mm.ReadyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var resp healthResponse
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+	if code, resp := ready(); code != http.StatusOK || len(resp.Checks) != 3 {
+		t.Fatalf("readyz = %d %+v", code, resp)
+	}
+
+	// неудачный снимок проваливает last_dump; сам dump повторял бы запись
+	// с backoff, поэтому статус подставляется напрямую
+	fs.lastDump.Store(&dumpStatus{err: os.ErrNotExist})
+	if err := fs.dumpCheck(ctx.Background()); !errors.Is(err, ErrDumpFailed) {
+		t.Fatalf("dumpCheck = %v", err)
+	}
+	if code, resp := ready(); code != http.StatusServiceUnavailable || resp.Status != "fail" {
+		t.Fatalf("readyz after failed dump = %d %+v", code, resp)
+	}
+	if err := fs.dump(ctx.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := ready(); code != http.StatusOK {
+		t.Fatalf("readyz after successful dump = %d", code)
+	}
+
+	rec := httptest.NewRecorder()
+	mm.HealthzHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("healthz = %d", rec.Code)
+	}
+}
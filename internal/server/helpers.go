@@ -1,6 +1,8 @@
 package server
 
 import (
+	"strconv"
+
 	s "metrics/internal/service"
 )
 
@@ -21,9 +23,28 @@ func getQuery(oper dbOperation, met *s.Metrics) string {
 
 func setVal(met *s.Metrics, val any) {
 	if v, ok := val.(int64); ok {
+		met.MType = s.Counter
 		met.Delta = &v
 	} else {
 		v, _ := val.(float64)
+		met.MType = s.Gauge
 		met.Value = &v
 	}
 }
+
+func formatValue(met *s.Metrics) string {
+	if met.IsCounter() {
+		return strconv.FormatInt(*met.Delta, 10)
+	}
+	return strconv.FormatFloat(*met.Value, 'f', -1, 64)
+}
+
+func metricValue(met *s.Metrics) (float64, bool) {
+	switch {
+	case met.IsCounter() && met.Delta != nil:
+		return float64(*met.Delta), true
+	case met.IsGauge() && met.Value != nil:
+		return *met.Value, true
+	}
+	return 0, false
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	log "metrics/internal/logger"
+	s "metrics/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+const defaultHistoryWindow = time.Hour
+
+// HistoryHandler отдает значения метрики за [from, to) в RFC3339;
+// по умолчанию - за последний час.
+func (mm *MetricManager) HistoryHandler(rw http.ResponseWriter, req *http.Request) {
+	hist, ok := mm.Storage.(historian)
+	if !ok {
+		http.Error(rw, "history is not supported by storage", http.StatusNotImplemented)
+		return
+	}
+	met, err := s.NewMetric(chi.URLParam(req, mtype), chi.URLParam(req, id), "")
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	to := time.Now()
+	from := to.Add(-defaultHistoryWindow)
+	query := req.URL.Query()
+	if v := query.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(rw, "bad from: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(rw, "bad to: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	samples, err := hist.History(req.Context(), met, from, to)
+	if errors.Is(err, ErrNoValue) {
+		http.Error(rw, "history is disabled", http.StatusNotImplemented)
+		return
+	} else if err != nil {
+		log.Ctx(req.Context()).Warn("HistoryHandler(): storage error", zap.Error(err))
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if samples == nil {
+		samples = []Sample{}
+	}
+	data, _ := json.Marshal(samples)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write(data)
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	ctx "context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	log "metrics/internal/logger"
+	s "metrics/internal/service"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const kvPermissions = 0o600
+
+var (
+	counterBucket = []byte(s.Counter)
+	gaugeBucket   = []byte(s.Gauge)
+)
+
+// KVStorage - встроенное хранилище на bbolt: счетчики и gauge лежат в
+// отдельных бакетах, значения хранятся в 8 байтах big-endian.
+type KVStorage struct {
+	db *bolt.DB
+}
+
+func NewKVStore(path string) (*KVStorage, error) {
+	db, err := bolt.Open(path, kvPermissions, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("newKV: open %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{counterBucket, gaugeBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return fmt.Errorf("create bucket %s: %w", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("newKV: %w", err)
+	}
+	return &KVStorage{db: db}, nil
+}
+
+// Put и PutBatch меняют дельты счетчиков на итоги, как MemStorage, но только
+// после коммита: при ошибке метрики вызывающего остаются прежними.
+func (kv *KVStorage) Put(_ ctx.Context, met *s.Metrics) (*s.Metrics, error) {
+	var total int64
+	err := kv.db.Update(func(tx *bolt.Tx) (err error) {
+		total, err = putKV(tx, met)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("kv put: %w", err)
+	}
+	setTotal(met, total)
+	return met, nil
+}
+
+func (kv *KVStorage) PutBatch(_ ctx.Context, mets []*s.Metrics) error {
+	totals := make([]int64, len(mets))
+	err := kv.db.Update(func(tx *bolt.Tx) (err error) {
+		for i, met := range mets {
+			if totals[i], err = putKV(tx, met); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("kv putBatch: %w", err)
+	}
+	for i, met := range mets {
+		setTotal(met, totals[i])
+	}
+	return nil
+}
+
+func setTotal(met *s.Metrics, total int64) {
+	if met.IsCounter() {
+		met.Delta = &total
+	}
+}
+
+// putKV записывает метрику и возвращает итог счетчика; met не меняется.
+func putKV(tx *bolt.Tx, met *s.Metrics) (int64, error) {
+	key := []byte(met.ID)
+	switch {
+	case met.IsCounter() && met.Delta != nil:
+		b := tx.Bucket(counterBucket)
+		total := *met.Delta
+		if old := b.Get(key); old != nil {
+			total += int64(binary.BigEndian.Uint64(old))
+		}
+		return total, b.Put(key, binary.BigEndian.AppendUint64(nil, uint64(total)))
+	case met.IsGauge() && met.Value != nil:
+		return 0, tx.Bucket(gaugeBucket).Put(key,
+			binary.BigEndian.AppendUint64(nil, math.Float64bits(*met.Value)))
+	case met.IsCounter(), met.IsGauge():
+		return 0, fmt.Errorf("%w: %s has no value", s.ErrInvalidVal, met.ID)
+	}
+	return 0, s.ErrInvalidType
+}
+
+func (kv *KVStorage) Get(_ ctx.Context, met *s.Metrics) (*s.Metrics, error) {
+	if !met.IsCounter() && !met.IsGauge() {
+		return nil, s.ErrInvalidType
+	}
+	var res *s.Metrics
+	_ = kv.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte(met.MType)).Get([]byte(met.ID)); v != nil {
+			res = decodeKV(met.MType, met.ID, v)
+		}
+		return nil
+	})
+	if res == nil {
+		return nil, ErrNoValue
+	}
+	return res, nil
+}
+
+func (kv *KVStorage) List(_ ctx.Context) ([]*s.Metrics, error) {
+	metrics := make([]*s.Metrics, 0, numAllMetrics)
+	err := kv.db.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{gaugeBucket, counterBucket} {
+			err := tx.Bucket(name).ForEach(func(k, v []byte) error {
+				metrics = append(metrics, decodeKV(string(name), string(k), v))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("kv list: %w", err)
+	}
+	return metrics, nil
+}
+
+// decodeKV не сохраняет raw: срезы bbolt действительны только внутри транзакции
+func decodeKV(mtype, id string, raw []byte) *s.Metrics {
+	met := &s.Metrics{ID: id, MType: mtype}
+	bits := binary.BigEndian.Uint64(raw)
+	if mtype == s.Counter {
+		delta := int64(bits)
+		met.Delta = &delta
+	} else {
+		val := math.Float64frombits(bits)
+		met.Value = &val
+	}
+	return met
+}
+
+func (kv *KVStorage) Close() {
+	if err := kv.db.Close(); err != nil {
+		log.Warn("KV storage close error")
+		return
+	}
+	log.Info("KV storage is closed;)")
+}
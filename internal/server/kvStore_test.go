@@ -0,0 +1,55 @@
+package server
+
+import (
+	ctx "context"
+	"path/filepath"
+	"testing"
+
+	s "metrics/internal/service"
+)
+
+func TestKVStore(t *testing.T) {
+	cx := ctx.Background()
+	path := filepath.Join(t.TempDir(), "metrics.kv")
+	kv, err := NewKVStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := kv.Put(cx, s.BuildMetric("PollCount", int64(2)))
+	if err != nil || *got.Delta != 2 {
+		t.Fatalf("put = %v, %v", got, err)
+	}
+	if got, _ = kv.Put(cx, s.BuildMetric("PollCount", int64(3))); *got.Delta != 5 {
+		t.Fatalf("counter total = %d, want 5", *got.Delta)
+	}
+	_ = kv.PutBatch(cx, []*s.Metrics{s.BuildMetric("Alloc", 1.5), s.BuildMetric("PollCount", int64(1))})
+
+	// неудачный батч откатывается и не меняет метрики вызывающего
+	delta := int64(10)
+	bad := []*s.Metrics{{ID: "PollCount", MType: s.Counter, Delta: &delta}, {ID: "x", MType: s.Gauge}}
+	if err := kv.PutBatch(cx, bad); err == nil {
+		t.Fatal("batch with invalid metric must fail")
+	}
+	if delta != 10 || *bad[0].Delta != 10 {
+		t.Fatalf("failed batch changed caller's delta: %d", *bad[0].Delta)
+	}
+	kv.Close()
+
+	kv, err = NewKVStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	counter, err := kv.Get(cx, &s.Metrics{ID: "PollCount", MType: s.Counter})
+	if err != nil || *counter.Delta != 6 {
+		t.Fatalf("counter after restart = %v, %v; want 6", counter, err)
+	}
+	gauge, err := kv.Get(cx, &s.Metrics{ID: "Alloc", MType: s.Gauge})
+	if err != nil || *gauge.Value != 1.5 {
+		t.Fatalf("gauge after restart = %v, %v", gauge, err)
+	}
+	if list, _ := kv.List(cx); len(list) != 2 {
+		t.Fatalf("list after restart = %v", list)
+	}
+}
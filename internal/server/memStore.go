@@ -3,78 +3,168 @@ package server
 import (
 	ctx "context"
 	"errors"
-	"sync"
 	"runtime"
+	"sync"
+	"sync/atomic"
 
 	log "metrics/internal/logger"
 	s "metrics/internal/service"
 )
 
-const metricsNumber = 31
+const (
+	metricsNumber = 31
+	memShards     = 32
+)
 
 var numAllMetrics = runtime.NumCPU() + metricsNumber
 
 var ErrNoValue = errors.New("no such value in storage")
 
+// memKey разделяет пространства имен gauge и counter, как таблицы в БД.
+type memKey struct {
+	mtype string
+	id    string
+}
+
+func keyOf(met *s.Metrics) memKey {
+	return memKey{mtype: met.MType, id: met.ID}
+}
+
+type memEntry struct {
+	met atomic.Pointer[s.Metrics]
+}
+
+// memShard хранит часть метрик. Записи сериализуются мьютексом шарда,
+// чтение идет без блокировок: карта заменяется целиком (copy-on-write)
+// только при появлении нового ключа, а сохраненные *s.Metrics больше не
+// меняются - обновление атомарно подменяет указатель в записи.
+type memShard struct {
+	mtx   sync.Mutex
+	items atomic.Pointer[map[memKey]*memEntry]
+}
+
+func newMemShard() *memShard {
+	sh := &memShard{}
+	items := make(map[memKey]*memEntry)
+	sh.items.Store(&items)
+	return sh
+}
+
+func (sh *memShard) load(key memKey) *memEntry {
+	return (*sh.items.Load())[key]
+}
+
+// put вызывается под sh.mtx
+func (sh *memShard) put(met *s.Metrics) {
+	key := keyOf(met)
+	entry := sh.load(key)
+	if entry == nil {
+		old := *sh.items.Load()
+		items := make(map[memKey]*memEntry, len(old)+1)
+		for k, v := range old {
+			items[k] = v
+		}
+		entry = &memEntry{}
+		items[key] = entry
+		sh.items.Store(&items)
+	}
+	met.MergeMetrics(entry.met.Load())
+	entry.met.Store(met)
+}
+
 type MemStorage struct {
-	items map[string]*s.Metrics
-	mtx   *sync.RWMutex
-	len   int
+	shards []*memShard
 }
 
 func NewMemStore() *MemStorage {
-	return &MemStorage{
-		items: make(map[string]*s.Metrics, metricsNumber),
-		mtx:   &sync.RWMutex{},
+	shards := make([]*memShard, memShards)
+	for i := range shards {
+		shards[i] = newMemShard()
 	}
+	return &MemStorage{shards: shards}
 }
 
-func (ms *MemStorage) Put(_ ctx.Context, met *s.Metrics) (*s.Metrics, error) {
-	ms.mtx.Lock()
-	oldMet, exists := ms.items[met.ID]
-	met.MergeMetrics(oldMet)
-	ms.items[met.ID] = met
-	if !exists {
-		ms.len++
+func shardIdx(key memKey) int {
+	h := uint32(2166136261) // FNV-1a
+	for i := 0; i < len(key.mtype); i++ {
+		h ^= uint32(key.mtype[i])
+		h *= 16777619
+	}
+	for i := 0; i < len(key.id); i++ {
+		h ^= uint32(key.id[i])
+		h *= 16777619
 	}
-	ms.mtx.Unlock()
+	return int(h % memShards)
+}
+
+func (ms *MemStorage) Put(_ ctx.Context, met *s.Metrics) (*s.Metrics, error) {
+	sh := ms.shards[shardIdx(keyOf(met))]
+	sh.mtx.Lock()
+	sh.put(met)
+	sh.mtx.Unlock()
 	return met, nil
 }
 
 func (ms *MemStorage) Get(_ ctx.Context, m *s.Metrics) (*s.Metrics, error) {
-	var err error
-	ms.mtx.RLock()
-	met, ok := ms.items[m.ID]
-	ms.mtx.RUnlock()
-	if !ok {
-		err = ErrNoValue
+	key := keyOf(m)
+	entry := ms.shards[shardIdx(key)].load(key)
+	if entry == nil {
+		return nil, ErrNoValue
 	}
-	return met, err
+	return entry.met.Load(), nil
 }
 
 func (ms *MemStorage) List(_ ctx.Context) ([]*s.Metrics, error) {
-	i := 0
-	ms.mtx.RLock()
-	metrics := make([]*s.Metrics, ms.len)
-	for _, met := range ms.items {
-		metrics[i] = met
-		i++
+	items := make([]map[memKey]*memEntry, len(ms.shards))
+	size := 0
+	for i, sh := range ms.shards {
+		items[i] = *sh.items.Load()
+		size += len(items[i])
+	}
+	metrics := make([]*s.Metrics, 0, size)
+	for _, shardItems := range items {
+		for _, entry := range shardItems {
+			metrics = append(metrics, entry.met.Load())
+		}
 	}
-	ms.mtx.RUnlock()
 	return metrics, nil
 }
 
-func (ms *MemStorage) PutBatch(cx ctx.Context, mets []*s.Metrics) error {
-	ms.mtx.Lock()
-	for _, met := range mets {
-		oldMet, exists := ms.items[met.ID]
-		met.MergeMetrics(oldMet)
-		ms.items[met.ID] = met
-		if !exists {
-			ms.len++
+// PutBatch раскладывает батч по шардам (сортировка подсчетом) и берет
+// блокировку каждого шарда один раз; порядок метрик внутри шарда сохраняется.
+func (ms *MemStorage) PutBatch(_ ctx.Context, mets []*s.Metrics) error {
+	var bounds [memShards + 1]int
+	idx := make([]uint8, len(mets))
+	for i, met := range mets {
+		idx[i] = uint8(shardIdx(keyOf(met)))
+		bounds[idx[i]+1]++
+	}
+	for i := 1; i <= memShards; i++ {
+		bounds[i] += bounds[i-1]
+	}
+	pos := bounds
+	grouped := make([]*s.Metrics, len(mets))
+	for i, met := range mets {
+		grouped[pos[idx[i]]] = met
+		pos[idx[i]]++
+	}
+	for i, sh := range ms.shards {
+		group := grouped[bounds[i]:bounds[i+1]]
+		if len(group) == 0 {
+			continue
 		}
+		sh.mtx.Lock()
+		for _, met := range group {
+			sh.put(met)
+		}
+		sh.mtx.Unlock()
 	}
-	ms.mtx.Unlock()
+	return nil
+}
+
+// reset очищает хранилище; конкурентный доступ должен исключать вызывающий.
+func (ms *MemStorage) reset(ctx.Context) error {
+	ms.shards = NewMemStore().shards
 	return nil
 }
 
@@ -0,0 +1,128 @@
+package server
+
+import (
+	ctx "context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	s "metrics/internal/service"
+)
+
+// lockedMemStorage - прежняя реализация MemStorage с одной блокировкой на
+// всю карту, оставлена для сравнения в бенчмарках.
+type lockedMemStorage struct {
+	items map[string]*s.Metrics
+	mtx   *sync.RWMutex
+}
+
+func newLockedMemStore() *lockedMemStorage {
+	return &lockedMemStorage{
+		items: make(map[string]*s.Metrics, metricsNumber),
+		mtx:   &sync.RWMutex{},
+	}
+}
+
+func (ms *lockedMemStorage) Put(_ ctx.Context, met *s.Metrics) (*s.Metrics, error) {
+	ms.mtx.Lock()
+	met.MergeMetrics(ms.items[met.ID])
+	ms.items[met.ID] = met
+	ms.mtx.Unlock()
+	return met, nil
+}
+
+func (ms *lockedMemStorage) Get(_ ctx.Context, m *s.Metrics) (*s.Metrics, error) {
+	ms.mtx.RLock()
+	met, ok := ms.items[m.ID]
+	ms.mtx.RUnlock()
+	if !ok {
+		return nil, ErrNoValue
+	}
+	return met, nil
+}
+
+func (ms *lockedMemStorage) PutBatch(_ ctx.Context, mets []*s.Metrics) error {
+	ms.mtx.Lock()
+	for _, met := range mets {
+		met.MergeMetrics(ms.items[met.ID])
+		ms.items[met.ID] = met
+	}
+	ms.mtx.Unlock()
+	return nil
+}
+
+type benchStore interface {
+	Put(ctx.Context, *s.Metrics) (*s.Metrics, error)
+	Get(ctx.Context, *s.Metrics) (*s.Metrics, error)
+	PutBatch(ctx.Context, []*s.Metrics) error
+}
+
+const benchIDs = 1024
+
+var benchNames = func() []string {
+	names := make([]string, benchIDs)
+	for i := range names {
+		names[i] = fmt.Sprintf("metric%d", i)
+	}
+	return names
+}()
+
+func benchMetric(r *rand.Rand) *s.Metrics {
+	name := benchNames[r.Intn(benchIDs)]
+	if r.Intn(2) == 0 {
+		return &s.Metrics{ID: name, MType: s.Counter, Delta: new(int64)}
+	}
+	return &s.Metrics{ID: name, MType: s.Gauge, Value: new(float64)}
+}
+
+func benchmarkStores(b *testing.B, run func(*testing.B, benchStore)) {
+	b.Run("locked", func(b *testing.B) { run(b, newLockedMemStore()) })
+	b.Run("sharded", func(b *testing.B) { run(b, NewMemStore()) })
+}
+
+func BenchmarkMemStorePut(b *testing.B) {
+	benchmarkStores(b, func(b *testing.B, st benchStore) {
+		b.RunParallel(func(pb *testing.PB) {
+			r := rand.New(rand.NewSource(rand.Int63()))
+			for pb.Next() {
+				_, _ = st.Put(ctx.Background(), benchMetric(r))
+			}
+		})
+	})
+}
+
+func BenchmarkMemStorePutBatch(b *testing.B) {
+	const batchSize = 64
+	benchmarkStores(b, func(b *testing.B, st benchStore) {
+		b.RunParallel(func(pb *testing.PB) {
+			r := rand.New(rand.NewSource(rand.Int63()))
+			batch := make([]*s.Metrics, batchSize)
+			for pb.Next() {
+				for i := range batch {
+					batch[i] = benchMetric(r)
+				}
+				_ = st.PutBatch(ctx.Background(), batch)
+			}
+		})
+	})
+}
+
+func BenchmarkMemStoreMixed(b *testing.B) {
+	benchmarkStores(b, func(b *testing.B, st benchStore) {
+		for _, name := range benchNames {
+			_, _ = st.Put(ctx.Background(), &s.Metrics{ID: name, MType: s.Gauge, Value: new(float64)})
+		}
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			r := rand.New(rand.NewSource(rand.Int63()))
+			for pb.Next() {
+				if r.Intn(10) == 0 {
+					_, _ = st.Put(ctx.Background(), benchMetric(r))
+					continue
+				}
+				_, _ = st.Get(ctx.Background(), &s.Metrics{ID: benchNames[r.Intn(benchIDs)], MType: s.Gauge})
+			}
+		})
+	})
+}
@@ -1,8 +1,13 @@
 package server
 
 import (
+	ctx "context"
+	"fmt"
 	"log"
+	"sync"
 	"testing"
+
+	s "metrics/internal/service"
 )
 
 func TestWrite(t *testing.T) {
@@ -16,3 +21,39 @@ func TestWrite(t *testing.T) {
 		log.Println("\n\nTEST:", test.name)
 	}
 }
+
+func TestMemStoreConcurrentCounters(t *testing.T) {
+	const (
+		writers = 8
+		rounds  = 500
+	)
+	cx := ctx.Background()
+	ms := NewMemStore()
+	var wg sync.WaitGroup
+	wg.Add(writers)
+	for w := 0; w < writers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				name := fmt.Sprintf("counter%d", i%10)
+				if w%2 == 0 {
+					_, _ = ms.Put(cx, s.BuildMetric(name, int64(1)))
+				} else {
+					_ = ms.PutBatch(cx, []*s.Metrics{s.BuildMetric(name, int64(1))})
+				}
+				_, _ = ms.Get(cx, &s.Metrics{ID: name, MType: s.Counter})
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	list, _ := ms.List(cx)
+	var total int64
+	for _, m := range list {
+		total += *m.Delta
+	}
+	if len(list) != 10 || total != writers*rounds {
+		t.Fatalf("expected 10 counters with total %d, got %d with total %d",
+			writers*rounds, len(list), total)
+	}
+}
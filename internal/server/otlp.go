@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	log "metrics/internal/logger"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	contentProtobuf = "application/x-protobuf"
+	contentJSON     = "application/json"
+)
+
+type otlpCodec struct {
+	contentType string
+	unmarshal   func([]byte, proto.Message) error
+	marshal     func(proto.Message) ([]byte, error)
+}
+
+var otlpCodecs = map[string]otlpCodec{
+	contentProtobuf: {contentProtobuf, proto.Unmarshal, proto.Marshal},
+	contentJSON: {contentJSON,
+		protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal,
+		protojson.Marshal},
+}
+
+// OTLPHandler принимает OTLP/HTTP экспорт метрик (/v1/metrics) в protobuf
+// или JSON. Неподдерживаемые типы возвращаются как partial success.
+func (mm *MetricManager) OTLPHandler(rw http.ResponseWriter, req *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	codec, ok := otlpCodecs[mediaType]
+	if !ok {
+		http.Error(rw, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.Ctx(req.Context()).Warn("OTLPHandler(): body read error", zap.Error(err))
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+
+	var export colmetricspb.ExportMetricsServiceRequest
+	if err := codec.unmarshal(body, &export); err != nil {
+		log.Ctx(req.Context()).Warn("OTLPHandler(): decode error", zap.Error(err))
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	batch, err := mm.otlp.Convert(req.Context(), &export)
+	if err != nil {
+		// ряды заняты другим запросом, а клиент не дождался: пусть повторит
+		log.Ctx(req.Context()).Warn("OTLPHandler(): convert error", zap.Error(err))
+		http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer batch.Discard()
+	if len(batch.Metrics) > 0 {
+		err = mm.PutBatch(req.Context(), batch.Metrics)
+		if errors.Is(err, ErrReservedID) {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			// 503 по спецификации OTLP означает "повторить позже"
+			log.Ctx(req.Context()).Warn("OTLPHandler(): storage error", zap.Error(err))
+			http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	batch.Commit()
+
+	resp := &colmetricspb.ExportMetricsServiceResponse{}
+	if batch.Rejected > 0 {
+		resp.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: batch.Rejected,
+			ErrorMessage:       strings.Join(batch.Errors, "; "),
+		}
+	}
+	data, err := codec.marshal(resp)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", codec.contentType)
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write(data)
+}
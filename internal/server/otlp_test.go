@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	ctx "context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	s "metrics/internal/service"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func otlpExport(delta int64) *colmetricspb.ExportMetricsServiceRequest {
+	return otlpExportNamed("requests", delta)
+}
+
+func otlpExportNamed(name string, delta int64) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+				{Name: name, Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+					IsMonotonic:            true,
+					AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+					DataPoints: []*metricspb.NumberDataPoint{{
+						Value: &metricspb.NumberDataPoint_AsInt{AsInt: delta},
+					}},
+				}}},
+				{Name: "quantiles", Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{
+					DataPoints: []*metricspb.SummaryDataPoint{{}},
+				}}},
+			}}},
+		}},
+	}
+}
+
+func TestOTLPHandler(t *testing.T) {
+	mm := NewMetricManager()
+	mm.Storage = NewMemStore()
+
+	var total int64
+	for i, contentType := range []string{contentProtobuf, contentJSON} {
+		codec := otlpCodecs[contentType]
+		body, err := codec.marshal(otlpExport(int64(i + 2)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += int64(i + 2)
+		req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		mm.OTLPHandler(rec, req)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != contentType {
+			t.Fatalf("%s: status %d, content type %q", contentType, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		var resp colmetricspb.ExportMetricsServiceResponse
+		if err := codec.unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: decode response: %v", contentType, err)
+		}
+		// summary не поддерживается и возвращается как partial success
+		if resp.GetPartialSuccess().GetRejectedDataPoints() != 1 {
+			t.Fatalf("%s: partial success = %v", contentType, resp.GetPartialSuccess())
+		}
+		met, err := mm.Get(ctx.Background(), &s.Metrics{ID: "requests", MType: s.Counter})
+		if err != nil || *met.Delta != total {
+			t.Fatalf("%s: stored %v, %v; want %d", contentType, met, err, total)
+		}
+	}
+
+	// отклоненный батч освобождает ряды: повтор не блокируется
+	for i := 0; i < 2; i++ {
+		body, _ := proto.Marshal(otlpExportNamed("__server.requests", 1))
+		req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentProtobuf)
+		rec := httptest.NewRecorder()
+		mm.OTLPHandler(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("reserved id: status %d", rec.Code)
+		}
+	}
+
+	body, _ := proto.Marshal(otlpExport(1))
+	req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	mm.OTLPHandler(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("text/plain: status %d", rec.Code)
+	}
+
+	// ряды заняты другим запросом, а клиент ушел: ответ 503, а не зависание
+	held, err := mm.otlp.Convert(ctx.Background(), otlpExport(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Discard()
+	cx, cancel := ctx.WithCancel(ctx.Background())
+	cancel()
+	req = httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body)).WithContext(cx)
+	req.Header.Set("Content-Type", contentProtobuf)
+	rec = httptest.NewRecorder()
+	mm.OTLPHandler(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("canceled wait: status %d", rec.Code)
+	}
+}
@@ -2,28 +2,28 @@ package server
 
 import (
 	"bytes"
+	"embed"
 	"errors"
 	"html/template"
+	"io/fs"
+	"net/http"
+	"sort"
+	"time"
 
 	log "metrics/internal/logger"
+	s "metrics/internal/service"
 )
 
-const getAllHTML = `<!DOCTYPE html>
-<html lang="en">
-  <head>
-    <meta charset="utf-8">
-    <title>Metrics list</title>
-  </head>
-  <body>
-  	<h1>All metrics</h1>
-	<ol>{{ range .Data}}
-	<li>{{ .Met}}</li>{{ end }}
-	</ol>
-  </body>
-</html>`
+//go:embed web
+var webFS embed.FS
+
+var dashboardTemplate = template.Must(template.ParseFS(webFS, "web/index.html"))
 
 type Item struct {
-	Met string
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	Updated string `json:"updated"`
 }
 
 type templateArgs struct {
@@ -31,9 +31,8 @@ type templateArgs struct {
 }
 
 func renderGetAll(data []Item) (*bytes.Buffer, error) {
-	indexTemplate := template.Must(template.New("metrics").Parse(getAllHTML))
 	buf := new(bytes.Buffer)
-	err := indexTemplate.Execute(buf, templateArgs{Data: data})
+	err := dashboardTemplate.Execute(buf, templateArgs{Data: data})
 	if err != nil {
 		log.Warn("error html template exec")
 		return nil, errors.Unwrap(err)
@@ -41,3 +40,37 @@ func renderGetAll(data []Item) (*bytes.Buffer, error) {
 
 	return buf, nil
 }
+
+func StaticHandler() http.Handler {
+	static, _ := fs.Sub(webFS, "web/static")
+	return http.StripPrefix("/static/", http.FileServer(http.FS(static)))
+}
+
+func (mm *MetricManager) buildItems(metrics []*s.Metrics) []Item {
+	list := make([]Item, 0, len(metrics))
+	for _, m := range metrics {
+		if m.Delta == nil && m.Value == nil {
+			continue
+		}
+		list = append(list, Item{
+			ID:      m.ID,
+			Type:    m.MType,
+			Value:   formatValue(m),
+			Updated: formatTime(mm.updates.get(m)),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ID == list[j].ID {
+			return list[i].Type < list[j].Type
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "—"
+	}
+	return t.Format(time.RFC3339)
+}
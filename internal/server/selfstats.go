@@ -0,0 +1,194 @@
+package server
+
+import (
+	ctx "context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "metrics/internal/logger"
+	"metrics/internal/selfstat"
+	s "metrics/internal/service"
+	"metrics/internal/tracing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+const selfStatsInterval = 10 * time.Second
+
+var ErrReservedID = fmt.Errorf("%w: prefix %q is reserved", s.ErrInvalidVal, selfstat.Prefix)
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// WithStats считает запросы и суммарную задержку по маршруту и статусу.
+// Маршрут берется из шаблона chi, чтобы ID метрик не зависели от значений.
+func WithStats(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: rw}
+		next.ServeHTTP(sw, req)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		route := "unmatched"
+		if rc := chi.RouteContext(req.Context()); rc != nil && rc.RoutePattern() != "" {
+			route = routeName(rc.RoutePattern())
+		}
+		status := strconv.Itoa(sw.status)
+		selfstat.Inc(selfstat.Name("http", route, status, "requests"))
+		selfstat.Add(selfstat.Name("http", route, status, "latency_us"),
+			time.Since(start).Microseconds())
+	})
+}
+
+// routeName: "/value/{type}/{id}" -> "value_type_id", "/" -> "root"
+func routeName(pattern string) string {
+	name := strings.NewReplacer("{", "", "}", "", "*", "").Replace(pattern)
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return "root"
+	}
+	return strings.ReplaceAll(name, "/", "_")
+}
+
+func storageBackend(st Storage) string {
+	switch st.(type) {
+	case *DataBase:
+		return "postgres"
+	case *SQLiteStorage:
+		return "sqlite"
+	case *KVStorage:
+		return "kv"
+	case *FileStorage:
+		return "file"
+	case *MemStorage:
+		return "mem"
+	}
+	return "other"
+}
+
+// observeStorage открывает спан вызова хранилища; возвращаемая функция
+// закрывает его и учитывает вызов. Отсутствие метрики ошибкой не считается.
+func (mm *MetricManager) observeStorage(cx ctx.Context, op string) (ctx.Context, func(error)) {
+	backend := storageBackend(mm.Storage)
+	cx, span := tracing.Start(cx, "storage."+op, attribute.String("storage.backend", backend))
+	start := time.Now()
+	return cx, func(err error) {
+		if isMissing(err) {
+			tracing.End(span, nil)
+		} else {
+			tracing.End(span, err)
+		}
+		mm.countStorage(backend, op, start, err)
+	}
+}
+
+func (mm *MetricManager) countStorage(backend, op string, start time.Time, err error) {
+	selfstat.Inc(selfstat.Name("storage", backend, op, "calls"))
+	selfstat.Add(selfstat.Name("storage", backend, op, "latency_us"), time.Since(start).Microseconds())
+	if err != nil && !isMissing(err) {
+		selfstat.Inc(selfstat.Name("storage", backend, op, "errors"))
+	}
+}
+
+func isMissing(err error) bool {
+	return errors.Is(err, ErrNoValue) ||
+		errors.Is(err, pgx.ErrNoRows) ||
+		errors.Is(err, sql.ErrNoRows)
+}
+
+func checkReserved(mets ...*s.Metrics) error {
+	for _, met := range mets {
+		if selfstat.IsReserved(met.ID) {
+			return fmt.Errorf("%w: %s", ErrReservedID, met.ID)
+		}
+	}
+	return nil
+}
+
+// flushSelfStats периодически пишет собственные метрики сервера в
+// хранилище в обход проверки зарезервированного префикса.
+func (mm *MetricManager) flushSelfStats(cx ctx.Context) {
+	ticker := time.NewTicker(selfStatsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cx.Done():
+			return
+		case <-ticker.C:
+		}
+		if all, err := mm.Storage.List(cx); err == nil {
+			selfstat.Set(selfstat.Name("storage", "metrics"), float64(len(all)))
+		}
+		mets := selfstat.Collect()
+		if err := mm.Storage.PutBatch(cx, mets); err != nil {
+			selfstat.Restore(mets)
+			log.Warn("self stats flush error", zap.Error(err))
+		}
+	}
+}
+
+// SelfMetricsHandler отдает собственные метрики сервера из хранилища в
+// текстовом формате Prometheus.
+func (mm *MetricManager) SelfMetricsHandler(rw http.ResponseWriter, req *http.Request) {
+	metrics, err := mm.List(req.Context())
+	if err != nil {
+		log.Ctx(req.Context()).Warn("SelfMetricsHandler(): storage error", zap.Error(err))
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	own := make([]*s.Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		if selfstat.IsReserved(m.ID) && (m.Delta != nil || m.Value != nil) {
+			own = append(own, m)
+		}
+	}
+	sort.Slice(own, func(i, j int) bool { return own[i].ID < own[j].ID })
+
+	var b strings.Builder
+	for _, m := range own {
+		name := promName(m.ID)
+		fmt.Fprintf(&b, "# TYPE %s %s\n%s %s\n", name, m.MType, name, formatValue(m))
+	}
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte(b.String()))
+}
+
+func promName(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, id)
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	ctx "context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	log "metrics/internal/logger"
+	s "metrics/internal/service"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	sqliteScheme     = "sqlite://"
+	sqliteMigrations = "file://migrations/sqlite"
+)
+
+var sqliteQueries = map[string]string{
+	insertGauge: `INSERT INTO gauge(id, value) VALUES(?, ?)
+		          ON CONFLICT(id)
+		          DO UPDATE SET value = excluded.value
+		          RETURNING value`,
+
+	insertCounter: `INSERT INTO counter(id, value) VALUES(?, ?)
+		            ON CONFLICT(id)
+		            DO UPDATE SET value = counter.value + excluded.value
+		            RETURNING value`,
+
+	selectGauge: `SELECT value FROM gauge WHERE id = ?`,
+
+	selectCounter: `SELECT value FROM counter WHERE id = ?`,
+
+	selectAll: `SELECT id, value FROM gauge
+		        UNION ALL
+		        SELECT id, value FROM counter`,
+}
+
+// SQLiteStorage - хранилище на встроенном SQLite (modernc.org/sqlite, без cgo)
+// с той же схемой и семантикой UPSERT, что и DataBase.
+type SQLiteStorage struct {
+	db    *sql.DB
+	stmts map[string]*sql.Stmt
+}
+
+func NewSQLite(cx ctx.Context, dsn string) (*SQLiteStorage, error) {
+	if err := runSQLiteMigrations(dsn); err != nil {
+		return nil, err
+	}
+	path := strings.TrimPrefix(dsn, sqliteScheme)
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("newSQLite: open: %w", err)
+	}
+	// SQLite допускает одного писателя, отдельные соединения дали бы SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	stmts := make(map[string]*sql.Stmt, len(sqliteQueries))
+	for name, query := range sqliteQueries {
+		stmt, err := db.PrepareContext(cx, query)
+		if err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("newSQLite: prepare %s: %w", name, err)
+		}
+		stmts[name] = stmt
+	}
+	return &SQLiteStorage{db: db, stmts: stmts}, nil
+}
+
+func runSQLiteMigrations(dsn string) error {
+	m, err := migrate.New(sqliteMigrations, dsn)
+	if err != nil {
+		return fmt.Errorf("sqlite migrate err: %w", err)
+	}
+	defer m.Close()
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("sqlite migrate err: %w", err)
+	}
+	log.Debug("success sqlite migrations...")
+	return nil
+}
+
+func (sl *SQLiteStorage) Put(cx ctx.Context, met *s.Metrics) (*s.Metrics, error) {
+	val, err := sl.put(cx, sl.stmts, met)
+	if err != nil {
+		return nil, err
+	}
+	setVal(met, val)
+	return met, nil
+}
+
+// put возвращает сохраненное значение; met не меняется.
+func (sl *SQLiteStorage) put(cx ctx.Context, stmts map[string]*sql.Stmt, met *s.Metrics) (any, error) {
+	if !met.IsCounter() && !met.IsGauge() {
+		return nil, s.ErrInvalidType
+	}
+	if (met.IsCounter() && met.Delta == nil) || (met.IsGauge() && met.Value == nil) {
+		return nil, fmt.Errorf("%w: %s has no value", s.ErrInvalidVal, met.ID)
+	}
+	var val any
+	stmt := stmts[getQuery(insertMetric, met)]
+	if err := stmt.QueryRowContext(cx, met.ToSlice()...).Scan(&val); err != nil {
+		return nil, fmt.Errorf("sqlite put: %w", err)
+	}
+	return val, nil
+}
+
+func (sl *SQLiteStorage) Get(cx ctx.Context, met *s.Metrics) (*s.Metrics, error) {
+	if !met.IsCounter() && !met.IsGauge() {
+		return nil, s.ErrInvalidType
+	}
+	var val any
+	err := sl.stmts[getQuery(selectMetric, met)].QueryRowContext(cx, met.ID).Scan(&val)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoValue
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sqlite get: %w", err)
+	}
+	res := &s.Metrics{ID: met.ID}
+	setVal(res, val)
+	return res, nil
+}
+
+func (sl *SQLiteStorage) List(cx ctx.Context) ([]*s.Metrics, error) {
+	rows, err := sl.stmts[selectAll].QueryContext(cx)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite list: %w", err)
+	}
+	defer rows.Close()
+	metrics := make([]*s.Metrics, 0, numAllMetrics)
+	for rows.Next() {
+		var met s.Metrics
+		var val any
+		if err := rows.Scan(&met.ID, &val); err != nil {
+			return nil, fmt.Errorf("sqlite list scan: %w", err)
+		}
+		setVal(&met, val)
+		metrics = append(metrics, &met)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite list rows: %w", err)
+	}
+	return metrics, nil
+}
+
+// PutBatch заменяет дельты счетчиков итогами только после коммита: при
+// откате метрики вызывающего остаются прежними.
+func (sl *SQLiteStorage) PutBatch(cx ctx.Context, mets []*s.Metrics) error {
+	tx, err := sl.db.BeginTx(cx, nil)
+	if err != nil {
+		return fmt.Errorf("sqlite begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmts := make(map[string]*sql.Stmt, 2)
+	for _, name := range []string{insertGauge, insertCounter} {
+		stmts[name] = tx.StmtContext(cx, sl.stmts[name])
+	}
+	vals := make([]any, len(mets))
+	for i, met := range mets {
+		if vals[i], err = sl.put(cx, stmts, met); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite commit: %w", err)
+	}
+	for i, met := range mets {
+		setVal(met, vals[i])
+	}
+	return nil
+}
+
+func (sl *SQLiteStorage) Close() {
+	for _, stmt := range sl.stmts {
+		_ = stmt.Close()
+	}
+	if err := sl.db.Close(); err != nil {
+		log.Warn("SQLite storage close error")
+		return
+	}
+	log.Info("SQLite storage is closed;)")
+}
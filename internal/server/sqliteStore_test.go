@@ -0,0 +1,93 @@
+package server
+
+import (
+	ctx "context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	s "metrics/internal/service"
+)
+
+func newTestSQLite(t *testing.T, path string) *SQLiteStorage {
+	t.Helper()
+	// миграции лежат в корне репозитория
+	wd, _ := os.Getwd()
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+	sl, err := NewSQLite(ctx.Background(), sqliteScheme+path)
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	return sl
+}
+
+func TestSQLiteStore(t *testing.T) {
+	cx := ctx.Background()
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	sl := newTestSQLite(t, path)
+
+	// UPSERT как у insertCounter в Postgres: дельты складываются
+	got, err := sl.Put(cx, s.BuildMetric("PollCount", int64(2)))
+	if err != nil || *got.Delta != 2 {
+		t.Fatalf("put counter = %v, %v", got, err)
+	}
+	got, _ = sl.Put(cx, s.BuildMetric("PollCount", int64(3)))
+	if *got.Delta != 5 {
+		t.Fatalf("counter total after put = %d, want 5", *got.Delta)
+	}
+	_, _ = sl.Put(cx, s.BuildMetric("Alloc", 1.5))
+	_, _ = sl.Put(cx, s.BuildMetric("Alloc", 2.0))
+
+	for i := 0; i < 2; i++ {
+		err = sl.PutBatch(cx, []*s.Metrics{
+			s.BuildMetric("PollCount", int64(10)),
+			s.BuildMetric("PollCount", int64(1)),
+			s.BuildMetric("Alloc", 7.25),
+		})
+		if err != nil {
+			t.Fatalf("put batch: %v", err)
+		}
+	}
+	// ошибочный батч откатывается целиком
+	bad := []*s.Metrics{s.BuildMetric("PollCount", int64(100)), {ID: "x", MType: s.Counter}}
+	if err := sl.PutBatch(cx, bad); err == nil {
+		t.Fatal("batch with invalid metric must fail")
+	}
+	// метрики откаченного батча сохраняют дельты: selfstat.Restore
+	// вернул бы итоги в счетчики
+	if *bad[0].Delta != 100 {
+		t.Fatalf("rolled back delta = %d, want 100", *bad[0].Delta)
+	}
+
+	counter, err := sl.Get(cx, &s.Metrics{ID: "PollCount", MType: s.Counter})
+	if err != nil || *counter.Delta != 27 {
+		t.Fatalf("counter = %v, %v; want 27", counter, err)
+	}
+	gauge, err := sl.Get(cx, &s.Metrics{ID: "Alloc", MType: s.Gauge})
+	if err != nil || *gauge.Value != 7.25 {
+		t.Fatalf("gauge = %v, %v; want 7.25", gauge, err)
+	}
+	if _, err := sl.Get(cx, &s.Metrics{ID: "Alloc", MType: s.Counter}); err != ErrNoValue {
+		t.Fatalf("gauge must not be visible as counter: %v", err)
+	}
+	sl.Close()
+
+	// данные переживают повторное открытие
+	sl = newTestSQLite(t, path)
+	defer sl.Close()
+	list, err := sl.List(cx)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("list = %v, %v", list, err)
+	}
+	for _, m := range list {
+		switch {
+		case m.ID == "PollCount" && m.IsCounter() && *m.Delta == 27:
+		case m.ID == "Alloc" && m.IsGauge() && *m.Value == 7.25:
+		default:
+			t.Errorf("unexpected metric in list: %+v", m)
+		}
+	}
+}
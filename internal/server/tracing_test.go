@@ -0,0 +1,39 @@
+package server
+
+import (
+	ctx "context"
+	"testing"
+
+	s "metrics/internal/service"
+	"metrics/internal/tracing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStorageSpans(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := tracing.SetProvider(sdktrace.NewSimpleSpanProcessor(exp), "test")
+	defer func() { _ = tp.Shutdown(ctx.Background()) }()
+
+	mm := NewMetricManager()
+	mm.Storage = NewMemStore()
+	cx, parent := tracing.Start(ctx.Background(), "handler")
+	met, _ := mm.Get(cx, &s.Metrics{ID: "Alloc", MType: s.Gauge})
+	parent.End()
+	if met != nil {
+		t.Fatalf("unexpected metric %v", met)
+	}
+
+	spans := exp.GetSpans()
+	if len(spans) != 2 || spans[0].Name != "storage.get" {
+		t.Fatalf("spans = %v", spans)
+	}
+	if spans[0].Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Error("storage span is not a child of the handler span")
+	}
+	// отсутствие метрики - не ошибка хранилища
+	if spans[0].Status.Code != 0 {
+		t.Errorf("status = %v", spans[0].Status)
+	}
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	s "metrics/internal/service"
+)
+
+type updateTracker struct {
+	mtx   sync.RWMutex
+	times map[string]time.Time
+}
+
+func newUpdateTracker() *updateTracker {
+	return &updateTracker{times: make(map[string]time.Time, metricsNumber)}
+}
+
+func (ut *updateTracker) touch(mets ...*s.Metrics) {
+	now := time.Now()
+	ut.mtx.Lock()
+	for _, m := range mets {
+		ut.times[m.MType+"/"+m.ID] = now
+	}
+	ut.mtx.Unlock()
+}
+
+func (ut *updateTracker) get(met *s.Metrics) time.Time {
+	ut.mtx.RLock()
+	defer ut.mtx.RUnlock()
+	return ut.times[met.MType+"/"+met.ID]
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	s "metrics/internal/service"
+
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+const (
+	walSuffix     = ".wal"
+	walHeaderSize = 8
+	walMaxRecord  = 64 << 20
+)
+
+var ErrWALCorrupted = errors.New("wal record is corrupted")
+
+// walRecord - запись журнала. Seq растет монотонно и сохраняется в снимке:
+// записи, уже вошедшие в снимок, при восстановлении пропускаются.
+type walRecord struct {
+	Seq     uint64       `json:"seq"`
+	Metrics []*s.Metrics `json:"metrics"`
+}
+
+// Запись WAL: uint32 длина | uint32 crc32 | json walRecord. Записи прежнего
+// формата (голый json-массив метрик) читаются с Seq=0.
+func encodeWALRecord(seq uint64, mets []*s.Metrics) ([]byte, error) {
+	payload, err := ffjson.Marshal(walRecord{Seq: seq, Metrics: mets})
+	if err != nil {
+		return nil, fmt.Errorf("wal encode: %w", err)
+	}
+	rec := make([]byte, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(rec[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(rec[4:8], crc32.ChecksumIEEE(payload))
+	copy(rec[walHeaderSize:], payload)
+	return rec, nil
+}
+
+type walReader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+func newWALReader(r io.Reader) *walReader {
+	return &walReader{r: bufio.NewReader(r)}
+}
+
+// next возвращает очередную запись. io.EOF - штатный конец журнала,
+// ErrWALCorrupted - недописанный или испорченный хвост.
+func (wr *walReader) next() (walRecord, error) {
+	var rec walRecord
+	var header [walHeaderSize]byte
+	n, err := io.ReadFull(wr.r, header[:])
+	if err == io.EOF {
+		return rec, io.EOF
+	}
+	if err != nil {
+		return rec, fmt.Errorf("%w: short header (%d bytes)", ErrWALCorrupted, n)
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size > walMaxRecord {
+		return rec, fmt.Errorf("%w: record size %d", ErrWALCorrupted, size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(wr.r, payload); err != nil {
+		return rec, fmt.Errorf("%w: short record", ErrWALCorrupted)
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return rec, fmt.Errorf("%w: checksum mismatch", ErrWALCorrupted)
+	}
+	if len(payload) > 0 && payload[0] == '[' {
+		err = ffjson.Unmarshal(payload, &rec.Metrics)
+	} else {
+		err = ffjson.Unmarshal(payload, &rec)
+	}
+	if err != nil {
+		return rec, fmt.Errorf("%w: %w", ErrWALCorrupted, err)
+	}
+	wr.offset += int64(walHeaderSize) + int64(size)
+	return rec, nil
+}
+
+func openWAL(path string, truncate bool) (*os.File, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if truncate {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, permissions)
+	if err != nil {
+		return nil, fmt.Errorf("open wal: %w", err)
+	}
+	return f, nil
+}
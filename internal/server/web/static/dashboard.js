@@ -0,0 +1,177 @@
+(function () {
+  "use strict";
+
+  const historyLen = 60;
+  const sparkWidth = 120;
+  const sparkHeight = 24;
+
+  const tbody = document.querySelector("#metrics tbody");
+  const search = document.getElementById("search");
+  const status = document.getElementById("status");
+  const history = new Map();
+  let sortKey = "id";
+  let sortDir = 1;
+
+  const key = (type, id) => type + "/" + id;
+
+  function remember(type, id, value) {
+    const k = key(type, id);
+    const points = history.get(k) || [];
+    const num = Number(value);
+    if (!Number.isFinite(num)) {
+      return;
+    }
+    points.push(num);
+    if (points.length > historyLen) {
+      points.shift();
+    }
+    history.set(k, points);
+  }
+
+  function sparkline(points) {
+    if (!points || points.length < 2) {
+      return "";
+    }
+    const min = Math.min(...points);
+    const max = Math.max(...points);
+    const span = max - min || 1;
+    const step = sparkWidth / (points.length - 1);
+    const coords = points.map((p, i) =>
+      (i * step).toFixed(1) + "," +
+      (sparkHeight - 1 - ((p - min) / span) * (sparkHeight - 2)).toFixed(1));
+    return '<svg width="' + sparkWidth + '" height="' + sparkHeight + '">' +
+      '<polyline points="' + coords.join(" ") + '"/></svg>';
+  }
+
+  function compare(a, b) {
+    const va = a.dataset[sortKey] || "";
+    const vb = b.dataset[sortKey] || "";
+    if (sortKey === "value") {
+      return (Number(va) - Number(vb)) * sortDir;
+    }
+    return va.localeCompare(vb) * sortDir;
+  }
+
+  function applySort() {
+    const rows = Array.from(tbody.rows);
+    rows.sort(compare);
+    rows.forEach((r) => tbody.appendChild(r));
+    document.querySelectorAll("th.sortable").forEach((th) => {
+      th.classList.toggle("asc", th.dataset.key === sortKey && sortDir > 0);
+      th.classList.toggle("desc", th.dataset.key === sortKey && sortDir < 0);
+    });
+  }
+
+  function applyFilter() {
+    const q = search.value.trim().toLowerCase();
+    Array.from(tbody.rows).forEach((r) => {
+      const text = (r.dataset.id + " " + r.dataset.type).toLowerCase();
+      r.hidden = q !== "" && !text.includes(q);
+    });
+  }
+
+  function renderRow(row, item) {
+    row.dataset.id = item.id;
+    row.dataset.type = item.type;
+    row.dataset.value = item.value;
+    row.dataset.updated = item.updated;
+    row.innerHTML = "";
+    const cells = [item.id, item.type, item.value, "", item.updated];
+    cells.forEach((text, i) => {
+      const td = document.createElement("td");
+      td.textContent = text;
+      if (i === 2) {
+        td.className = "num";
+      }
+      if (i === 3) {
+        td.className = "spark";
+        td.innerHTML = sparkline(history.get(key(item.type, item.id)));
+      }
+      row.appendChild(td);
+    });
+  }
+
+  function update(items) {
+    const rows = new Map();
+    Array.from(tbody.rows).forEach((r) => rows.set(key(r.dataset.type, r.dataset.id), r));
+    items.forEach((item) => {
+      remember(item.type, item.id, item.value);
+      const k = key(item.type, item.id);
+      let row = rows.get(k);
+      if (!row) {
+        row = tbody.insertRow();
+        rows.set(k, row);
+      }
+      renderRow(row, item);
+    });
+    applySort();
+    applyFilter();
+  }
+
+  function connect() {
+    if (!window.EventSource) {
+      return;
+    }
+    const es = new EventSource("/stream");
+    es.onopen = () => {
+      status.textContent = "live";
+      status.classList.add("live");
+    };
+    es.addEventListener("metric", (ev) => {
+      const met = JSON.parse(ev.data);
+      const value = met.type === "counter" ? met.delta : met.value;
+      update([{
+        id: met.id,
+        type: met.type,
+        value: String(value),
+        updated: met.time.replace(/\.\d+/, ""),
+      }]);
+    });
+    es.onerror = () => {
+      status.textContent = "reconnecting…";
+      status.classList.remove("live");
+    };
+  }
+
+  document.querySelectorAll("th.sortable").forEach((th) => {
+    th.addEventListener("click", () => {
+      if (sortKey === th.dataset.key) {
+        sortDir = -sortDir;
+      } else {
+        sortKey = th.dataset.key;
+        sortDir = 1;
+      }
+      applySort();
+    });
+  });
+  search.addEventListener("input", applyFilter);
+
+  // история есть не у всех хранилищ: 501 означает, что копим точки из потока
+  function loadHistory(row) {
+    const k = key(row.dataset.type, row.dataset.id);
+    return fetch("/history/" + encodeURIComponent(row.dataset.type) + "/" +
+        encodeURIComponent(row.dataset.id))
+      .then((resp) => (resp.ok ? resp.json() : null))
+      .then((samples) => {
+        if (!samples || samples.length === 0) {
+          return;
+        }
+        const points = samples.slice(-historyLen).map((smp) => smp.value);
+        history.set(k, points.concat(history.get(k) || []).slice(-historyLen));
+        row.querySelector("td.spark").innerHTML = sparkline(history.get(k));
+      })
+      .catch(() => {});
+  }
+
+  const rows = Array.from(tbody.rows);
+  rows.forEach((r) => remember(r.dataset.type, r.dataset.id, r.dataset.value));
+  if (rows.length > 0 && window.fetch) {
+    loadHistory(rows[0]).then(() => {
+      if ((history.get(key(rows[0].dataset.type, rows[0].dataset.id)) || []).length > 1) {
+        rows.slice(1).forEach(loadHistory);
+      }
+    });
+  }
+  applySort();
+  connect();
+})();
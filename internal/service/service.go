@@ -14,8 +14,8 @@ import (
 )
 
 const (
-	gauge   = "gauge"
-	counter = "counter"
+	Gauge   = "gauge"
+	Counter = "counter"
 )
 
 var (
@@ -88,10 +88,10 @@ func BuildMetric(name string, val any) *Metrics {
 
 	switch v := val.(type) {
 	case float64:
-		met.MType = gauge
+		met.MType = Gauge
 		met.Value = &v
 	case int64:
-		met.MType = counter
+		met.MType = Counter
 		met.Delta = &v
 	default:
 		metricsPool.Put(met)
@@ -112,7 +112,7 @@ func (met *Metrics) String() string {
 }
 
 func (met *Metrics) MergeMetrics(met2 *Metrics) {
-	if met2 == nil {
+	if met2 == nil || !met.IsCounter() {
 		return
 	}
 	if met2.IsCounter() && met2.Delta != nil {
@@ -131,11 +131,11 @@ func (met Metrics) ToSlice() []any {
 }
 
 func (met *Metrics) IsGauge() bool {
-	return met.MType == gauge
+	return met.MType == Gauge
 }
 
 func (met *Metrics) IsCounter() bool {
-	return met.MType == counter
+	return met.MType == Counter
 }
 
 func Retry(cx ctx.Context, fn func() error) error {
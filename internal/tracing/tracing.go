@@ -0,0 +1,158 @@
+// Package tracing настраивает OpenTelemetry: экспорт спанов по OTLP/HTTP и
+// распространение W3C trace context между агентом и сервером.
+package tracing
+
+import (
+	ctx "context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	instrumentation = "metrics"
+	shutdownTimeout = 5 * time.Second
+)
+
+func init() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+var provider *sdktrace.TracerProvider
+
+// Init включает экспорт спанов на endpoint (host:port OTLP/HTTP). Без
+// endpoint остается no-op провайдер, но trace context все равно
+// пробрасывается.
+func Init(cx ctx.Context, endpoint, service string) error {
+	if endpoint == "" {
+		return nil
+	}
+	exp, err := otlptracehttp.New(cx,
+		otlptracehttp.WithEndpoint(endpoint),
+		otlptracehttp.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("otlp exporter: %w", err)
+	}
+	provider = SetProvider(sdktrace.NewBatchSpanProcessor(exp), service)
+	return nil
+}
+
+// Shutdown досылает накопленные спаны; вызывается при остановке приложения.
+func Shutdown() {
+	if provider == nil {
+		return
+	}
+	cx, cancel := ctx.WithTimeout(ctx.Background(), shutdownTimeout)
+	defer cancel()
+	_ = provider.Shutdown(cx)
+}
+
+// SetProvider регистрирует глобальный провайдер с заданным процессором;
+// в тестах сюда передается процессор с tracetest.InMemoryExporter.
+func SetProvider(sp sdktrace.SpanProcessor, service string) *sdktrace.TracerProvider {
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(sp),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(service))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp
+}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+func Start(cx ctx.Context, name string, attrs ...attribute.KeyValue) (ctx.Context, trace.Span) {
+	return Tracer().Start(cx, name, trace.WithAttributes(attrs...))
+}
+
+// End завершает спан, отмечая ошибку.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject добавляет trace context текущего спана в заголовки запроса.
+func Inject(cx ctx.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(cx, propagation.HeaderCarrier(header))
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Server - внешний middleware: извлекает trace context из заголовков и
+// открывает серверный спан; имя уточняется шаблоном маршрута chi.
+func Server(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		cx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		cx, span := Tracer().Start(cx, req.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(req.Method),
+				semconv.URLPath(req.URL.Path),
+			))
+		defer span.End()
+
+		sw := &statusWriter{ResponseWriter: rw}
+		next.ServeHTTP(sw, req.WithContext(cx))
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		if rc := chi.RouteContext(req.Context()); rc != nil && rc.RoutePattern() != "" {
+			span.SetName(req.Method + " " + rc.RoutePattern())
+			span.SetAttributes(semconv.HTTPRoute(rc.RoutePattern()))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(sw.status))
+		if sw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+	})
+}
+
+// Middleware оборачивает middleware в спан с именем name; спан покрывает
+// и все, что middleware вызывает дальше по цепочке.
+func Middleware(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return Handler(name, mw(next))
+	}
+}
+
+func Handler(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		cx, span := Tracer().Start(req.Context(), name)
+		defer span.End()
+		h.ServeHTTP(rw, req.WithContext(cx))
+	})
+}
+
+func HandlerFunc(name string, fn http.HandlerFunc) http.HandlerFunc {
+	return Handler(name, fn).ServeHTTP
+}
@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	ctx "context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestPropagation(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := SetProvider(sdktrace.NewSimpleSpanProcessor(exp), "test")
+	defer func() { _ = tp.Shutdown(ctx.Background()) }()
+
+	router := chi.NewRouter()
+	router.Use(Server)
+	router.Use(Middleware("passthrough", func(next http.Handler) http.Handler { return next }))
+	router.Post("/updates/", HandlerFunc("BatchHandler", func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	cx, parent := Start(ctx.Background(), "agent.report")
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/updates/", nil)
+	Inject(cx, req.Header)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	parent.End()
+
+	spans := exp.GetSpans()
+	names := make(map[string]bool)
+	for _, span := range spans {
+		names[span.Name] = true
+		if span.SpanContext.TraceID() != parent.SpanContext().TraceID() {
+			t.Errorf("span %s has foreign trace id", span.Name)
+		}
+	}
+	for _, want := range []string{"agent.report", "POST /updates", "passthrough", "BatchHandler"} {
+		if !names[want] {
+			t.Errorf("missing span %q in %v", want, names)
+		}
+	}
+}
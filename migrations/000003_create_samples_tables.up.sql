@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS gauge_samples(
+   id VARCHAR(255) NOT NULL,
+   ts TIMESTAMPTZ NOT NULL,
+   value DOUBLE PRECISION NOT NULL
+) PARTITION BY RANGE (ts);
+CREATE INDEX IF NOT EXISTS gauge_samples_id_ts ON gauge_samples (id, ts);
+CREATE TABLE IF NOT EXISTS counter_samples(
+   id VARCHAR(255) NOT NULL,
+   ts TIMESTAMPTZ NOT NULL,
+   value BIGINT NOT NULL
+) PARTITION BY RANGE (ts);
+CREATE INDEX IF NOT EXISTS counter_samples_id_ts ON counter_samples (id, ts);
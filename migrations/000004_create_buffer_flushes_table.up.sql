@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS buffer_flushes(
+   token VARCHAR(64) PRIMARY KEY,
+   applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
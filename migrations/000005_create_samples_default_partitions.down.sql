@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS gauge_samples_default;
+DROP TABLE IF EXISTS counter_samples_default;
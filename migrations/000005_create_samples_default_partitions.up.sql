@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS gauge_samples_default PARTITION OF gauge_samples DEFAULT;
+CREATE TABLE IF NOT EXISTS counter_samples_default PARTITION OF counter_samples DEFAULT;
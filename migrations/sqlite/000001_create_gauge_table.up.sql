@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS gauge(
+   id VARCHAR(255) PRIMARY KEY,
+   value DOUBLE PRECISION NOT NULL
+);
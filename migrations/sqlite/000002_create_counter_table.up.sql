@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS counter(
+	id VARCHAR(255) PRIMARY KEY,
+	value BIGINT NOT NULL
+);